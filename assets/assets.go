@@ -0,0 +1,108 @@
+// Package assets provide templates and static files used by the forum. All
+// files are embedded into the binary. In development mode, when DEV=1
+// environment variable is set, files are read from the disk instead, so
+// that they can be changed without rebuilding.
+package assets
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+//go:embed templates static themes locales
+var embedded embed.FS
+
+var dev = os.Getenv("DEV") == "1"
+
+// Templates return file system with all HTML templates.
+func Templates() fs.FS {
+	return dirFS("templates")
+}
+
+// TemplateDirs return directories with HTML templates of the default theme
+// and of all themes, including those installed in given directory. Templates
+// are read from the disk only in development mode, otherwise nil is
+// returned.
+func TemplateDirs(themesDir string) []string {
+	if !dev {
+		return nil
+	}
+	dirs := []string{"assets/templates"}
+	for _, root := range []string{"assets/themes", themesDir} {
+		if root == "" {
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(root, "*", "templates"))
+		if err != nil {
+			continue
+		}
+		dirs = append(dirs, matches...)
+	}
+	return dirs
+}
+
+// StaticDirs return directories with static files of the forum and of all
+// themes, including those installed in given directory. Like TemplateDirs,
+// it return nil unless in development mode.
+func StaticDirs(themesDir string) []string {
+	if !dev {
+		return nil
+	}
+	dirs := []string{"assets/static"}
+	for _, root := range []string{"assets/themes", themesDir} {
+		if root == "" {
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(root, "*", "static"))
+		if err != nil {
+			continue
+		}
+		dirs = append(dirs, matches...)
+	}
+	return dirs
+}
+
+// Locales return file system with message catalogs.
+func Locales() fs.FS {
+	return dirFS("locales")
+}
+
+// Static return file system with all static files.
+func Static() fs.FS {
+	return dirFS("static")
+}
+
+func dirFS(name string) fs.FS {
+	if dev {
+		// relative to the repository root, which is where the server is
+		// expected to be started from during development
+		return os.DirFS("assets/" + name)
+	}
+	sub, err := fs.Sub(embedded, name)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// Overlay return file system that serve files from given directory and
+// fallback to the base file system for files missing in the directory.
+func Overlay(dir string, base fs.FS) fs.FS {
+	return &overlay{top: os.DirFS(dir), base: base}
+}
+
+type overlay struct {
+	top  fs.FS
+	base fs.FS
+}
+
+func (o *overlay) Open(name string) (fs.File, error) {
+	f, err := o.top.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.base.Open(name)
+	}
+	return f, err
+}
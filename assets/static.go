@@ -0,0 +1,304 @@
+package assets
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/fsnotify/fsnotify"
+)
+
+// StaticFiles serve static files under fingerprinted names, containing the
+// hash of the file content, for example "css/bb.1f3a9b2c04d7.css". Because
+// the name changes together with the content, files are served with far
+// future cache headers. Every file is compressed once, when loaded, and
+// served compressed to clients accepting it.
+//
+// In development mode files are loaded again by Watch whenever any of them
+// changes, so that changes are visible without restarting the server.
+type StaticFiles struct {
+	roots  []staticRoot
+	prefix string
+
+	mu    sync.RWMutex
+	urls  map[string]string      // file name to its URL
+	files map[string]*staticFile // fingerprinted name to file
+}
+
+// staticRoot is a file system mounted at given directory.
+type staticRoot struct {
+	dir  string
+	fsys fs.FS
+}
+
+type staticFile struct {
+	contentType string
+	raw         []byte
+	gzip        []byte
+	brotli      []byte
+}
+
+// NewStaticFiles load all files from given file system. Prefix is the URL
+// path the handler is mounted at, for example "/static/".
+func NewStaticFiles(fsys fs.FS, prefix string) (*StaticFiles, error) {
+	s := &StaticFiles{
+		roots:  []staticRoot{{dir: ".", fsys: fsys}},
+		prefix: prefix,
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Mount serve files from given file system as if they were stored in given
+// directory.
+func (s *StaticFiles) Mount(dir string, fsys fs.FS) error {
+	s.mu.Lock()
+	s.roots = append(s.roots, staticRoot{dir: dir, fsys: fsys})
+	s.mu.Unlock()
+	return s.load()
+}
+
+func (s *StaticFiles) load() error {
+	s.mu.RLock()
+	roots := s.roots
+	s.mu.RUnlock()
+
+	urls := make(map[string]string)
+	files := make(map[string]*staticFile)
+	for _, root := range roots {
+		if err := loadStaticRoot(root, urls, files, s.prefix); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	s.urls = urls
+	s.files = files
+	s.mu.Unlock()
+	return nil
+}
+
+func loadStaticRoot(root staticRoot, urls map[string]string, files map[string]*staticFile, prefix string) error {
+	return fs.WalkDir(root.fsys, ".", func(fpath string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		raw, err := fs.ReadFile(root.fsys, fpath)
+		if err != nil {
+			return err
+		}
+		name := path.Join(root.dir, fpath)
+		f := &staticFile{
+			contentType: mime.TypeByExtension(path.Ext(name)),
+			raw:         raw,
+		}
+		if f.contentType == "" {
+			f.contentType = http.DetectContentType(raw)
+		}
+		if !dev && compressible(f.contentType) {
+			if f.gzip, err = gzipCompress(raw); err != nil {
+				return err
+			}
+			if f.brotli, err = brotliCompress(raw); err != nil {
+				return err
+			}
+		}
+		fname := fingerprint(name, raw)
+		files[fname] = f
+		urls[name] = prefix + fname
+		return nil
+	})
+}
+
+// Watch load all files again whenever any file in given directories or
+// their subdirectories changes.
+func (s *StaticFiles) Watch(dirs ...string) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(fpath string, d fs.DirEntry, err error) error {
+			if err != nil || !d.IsDir() {
+				return err
+			}
+			return w.Add(fpath)
+		})
+		if err != nil {
+			w.Close()
+			return fmt.Errorf("cannot watch %q: %s", dir, err)
+		}
+	}
+	go s.watch(w)
+	return nil
+}
+
+func (s *StaticFiles) watch(w *fsnotify.Watcher) {
+	// a single save often produces several events, so files are loaded
+	// only once the changes settle
+	var reload <-chan time.Time
+	for {
+		select {
+		case _, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			reload = time.After(100 * time.Millisecond)
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("static files watcher error: %s", err)
+		case <-reload:
+			reload = nil
+			if err := s.load(); err != nil {
+				log.Printf("cannot load static files: %s", err)
+			} else {
+				log.Printf("static files reloaded")
+			}
+		}
+	}
+}
+
+// fingerprint return file name with the content hash inserted before the
+// extension.
+func fingerprint(name string, content []byte) string {
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:6])
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+func compressible(contentType string) bool {
+	return strings.HasPrefix(contentType, "text/") ||
+		strings.HasPrefix(contentType, "application/javascript") ||
+		strings.HasPrefix(contentType, "image/svg+xml")
+}
+
+func gzipCompress(raw []byte) ([]byte, error) {
+	var b bytes.Buffer
+	w, err := gzip.NewWriterLevel(&b, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(raw); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func brotliCompress(raw []byte) ([]byte, error) {
+	var b bytes.Buffer
+	w := brotli.NewWriterLevel(&b, brotli.BestCompression)
+	if _, err := w.Write(raw); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// URL return fingerprinted URL of the file with given name. Unknown files
+// are linked without fingerprint.
+func (s *StaticFiles) URL(name string) string {
+	s.mu.RLock()
+	url, ok := s.urls[name]
+	s.mu.RUnlock()
+	if !ok {
+		log.Printf("unknown static file %q", name)
+		return s.prefix + name
+	}
+	return url
+}
+
+func (s *StaticFiles) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, s.prefix)
+	s.mu.RLock()
+	f, ok := s.files[name]
+	s.mu.RUnlock()
+	if !ok {
+		// files requested by their original name, without fingerprint, are
+		// still served, but must not be cached for long
+		s.serveUnversioned(w, r, name)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", f.contentType)
+	h.Set("Cache-Control", "public, max-age=31536000, immutable")
+	h.Add("Vary", "Accept-Encoding")
+
+	content := f.raw
+	accept := r.Header.Get("Accept-Encoding")
+	switch {
+	case f.brotli != nil && acceptsEncoding(accept, "br"):
+		h.Set("Content-Encoding", "br")
+		content = f.brotli
+	case f.gzip != nil && acceptsEncoding(accept, "gzip"):
+		h.Set("Content-Encoding", "gzip")
+		content = f.gzip
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+}
+
+func (s *StaticFiles) serveUnversioned(w http.ResponseWriter, r *http.Request, name string) {
+	s.mu.RLock()
+	roots := s.roots
+	s.mu.RUnlock()
+
+	var (
+		raw []byte
+		err = fs.ErrNotExist
+	)
+	// mounted roots are more specific, so they are checked first
+	for i := len(roots) - 1; i >= 0 && err != nil; i-- {
+		root := roots[i]
+		if root.dir == "." {
+			raw, err = fs.ReadFile(root.fsys, name)
+		} else if rel := strings.TrimPrefix(name, root.dir+"/"); rel != name {
+			raw, err = fs.ReadFile(root.fsys, rel)
+		}
+	}
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(raw))
+}
+
+// acceptsEncoding return true if Accept-Encoding header value allows given
+// content encoding.
+func acceptsEncoding(header, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		if strings.TrimSpace(fields[0]) != encoding {
+			continue
+		}
+		for _, param := range fields[1:] {
+			if strings.Replace(param, " ", "", -1) == "q=0" {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
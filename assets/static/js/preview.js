@@ -0,0 +1,37 @@
+(function () {
+	var forms = document.querySelectorAll('form[data-preview]');
+	Array.prototype.forEach.call(forms, function (form) {
+		var content = form.querySelector('textarea[name=content]');
+		var preview = form.querySelector('.markdown-preview');
+		var writeTab = form.querySelector('[data-tab=write]');
+		var previewTab = form.querySelector('[data-tab=preview]');
+
+		function show(previewing) {
+			content.style.display = previewing ? 'none' : '';
+			preview.hidden = !previewing;
+			writeTab.classList.toggle('active', !previewing);
+			previewTab.classList.toggle('active', previewing);
+		}
+
+		writeTab.addEventListener('click', function (e) {
+			e.preventDefault();
+			show(false);
+			content.focus();
+		});
+		previewTab.addEventListener('click', function (e) {
+			e.preventDefault();
+			var body = new FormData();
+			body.append('content', content.value);
+			var xhr = new XMLHttpRequest();
+			xhr.open('POST', '/preview/');
+			xhr.onload = function () {
+				if (xhr.status !== 200) {
+					return;
+				}
+				preview.innerHTML = xhr.responseText;
+				show(true);
+			};
+			xhr.send(body);
+		});
+	});
+})();
@@ -0,0 +1,87 @@
+package assets
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+)
+
+// DefaultTheme is the built-in theme without any customizations.
+const DefaultTheme = "light"
+
+// Theme is a directory containing optional "templates" directory with HTML
+// templates that redefine the default ones and optional "static" directory
+// with static files. If the static directory contains "theme.css" file, it is
+// included by every page after the default stylesheets.
+type Theme struct {
+	Name      string
+	Templates fs.FS // nil if theme does not change templates
+	Static    fs.FS // nil if theme has no static files
+	HasCSS    bool
+}
+
+// Themes return built-in themes and themes installed in given directory,
+// sorted by name. Installed theme replace built-in theme of the same name.
+// Directory is optional.
+func Themes(dir string) ([]*Theme, error) {
+	themes := map[string]*Theme{
+		DefaultTheme: {Name: DefaultTheme},
+	}
+	if err := readThemes(dirFS("themes"), themes); err != nil {
+		return nil, err
+	}
+	if dir != "" {
+		if err := readThemes(os.DirFS(dir), themes); err != nil {
+			return nil, err
+		}
+	}
+
+	list := make([]*Theme, 0, len(themes))
+	for _, t := range themes {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+func readThemes(fsys fs.FS, themes map[string]*Theme) error {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		tfs, err := fs.Sub(fsys, e.Name())
+		if err != nil {
+			return err
+		}
+		t := &Theme{Name: e.Name()}
+		if t.Templates, err = subdir(tfs, "templates"); err != nil {
+			return err
+		}
+		if t.Static, err = subdir(tfs, "static"); err != nil {
+			return err
+		}
+		if t.Static != nil {
+			_, err := fs.Stat(t.Static, "theme.css")
+			t.HasCSS = err == nil
+		}
+		themes[t.Name] = t
+	}
+	return nil
+}
+
+// subdir return file system of given directory or nil if it does not
+// exist.
+func subdir(fsys fs.FS, name string) (fs.FS, error) {
+	if _, err := fs.Stat(fsys, name); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return fs.Sub(fsys, name)
+}
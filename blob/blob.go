@@ -0,0 +1,18 @@
+// Package blob provides storage for binary objects, such as files attached
+// to forum messages.
+package blob
+
+import (
+	"errors"
+	"io"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// Storage is a key-value store for binary objects. Keys are provided by the
+// caller and are expected to be safe to use as a file name. Writing an object
+// under existing key replaces it.
+type Storage interface {
+	Put(key, contentType string, content io.Reader) error
+	Get(key string) (io.ReadCloser, error)
+}
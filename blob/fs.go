@@ -0,0 +1,61 @@
+package blob
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// FSStorage is a Storage implementation that keeps objects as files in a
+// local directory.
+type FSStorage struct {
+	root string
+}
+
+func NewFSStorage(root string) (*FSStorage, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &FSStorage{root: root}, nil
+}
+
+// path return file path for given key. Files are spread into subdirectories
+// to avoid having too many files in a single directory.
+func (s *FSStorage) path(key string) string {
+	if len(key) < 4 {
+		return filepath.Join(s.root, key)
+	}
+	return filepath.Join(s.root, key[:2], key[2:4], key)
+}
+
+func (s *FSStorage) Put(key, contentType string, content io.Reader) error {
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// write to temporary file first, so that readers never see partially
+	// written content
+	fd, err := ioutil.TempFile(filepath.Dir(path), ".upload-")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fd, content); err != nil {
+		fd.Close()
+		os.Remove(fd.Name())
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		os.Remove(fd.Name())
+		return err
+	}
+	return os.Rename(fd.Name(), path)
+}
+
+func (s *FSStorage) Get(key string) (io.ReadCloser, error) {
+	fd, err := os.Open(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return fd, err
+}
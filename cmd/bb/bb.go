@@ -1,14 +1,20 @@
 package main
 
 import (
+	"expvar"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"strings"
 	"time"
+	_ "time/tzdata" // timezones chosen by users must load on any system
 
+	"github.com/husio/bb/assets"
+	"github.com/husio/bb/blob"
 	"github.com/husio/bb/forum"
+	"github.com/husio/bb/i18n"
 	"github.com/husio/bb/tmpl"
 	"github.com/julienschmidt/httprouter"
 	"golang.org/x/net/context"
@@ -27,9 +33,11 @@ func (w *respwrt) WriteHeader(code int) {
 func ctxhandler(ctx context.Context, fn func(context.Context, http.ResponseWriter, *http.Request)) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		rw := &respwrt{code: http.StatusOK, ResponseWriter: w}
+		// pages are rendered in the language negotiated with the client
+		rw.Header().Add("Vary", "Accept-Language")
 		c := forum.WithParams(ctx, ps)
 		start := time.Now()
-		fn(c, rw, r)
+		fn(c, tmpl.Negotiate(rw, r), r)
 		path := r.URL.String() + strings.Repeat(".", 60-len(r.URL.String()))
 		fmt.Printf("%4s %d %s %s\n", r.Method, rw.code, path, time.Now().Sub(start))
 	}
@@ -37,18 +45,106 @@ func ctxhandler(ctx context.Context, fn func(context.Context, http.ResponseWrite
 
 func main() {
 	httpAddrFl := flag.String("addr", "localhost:8000", "HTTP server address")
-	staticsFl := flag.String("statics", "", "Optional static files directory")
+	debugAddrFl := flag.String("debug-addr", "", "Optional HTTP server address exposing runtime and store cache metrics at /debug/vars. It must not be reachable by forum users")
+	staticsFl := flag.String("statics", "", "Optional directory with static files served instead of the embedded ones")
+	templatesFl := flag.String("templates", "", "Optional directory with HTML templates redefining the embedded ones")
+	themesFl := flag.String("themes", "", "Optional directory with installed themes")
+	themeFl := flag.String("theme", assets.DefaultTheme, "Theme used unless user choose a different one")
+	blobsFl := flag.String("blobs", "blobs", "Attachments storage directory")
+	s3EndpointFl := flag.String("s3-endpoint", "", "Optional S3 compatible attachments storage URL. Credentials are read from S3_ACCESS_KEY and S3_SECRET_KEY environment variables")
+	s3RegionFl := flag.String("s3-region", "us-east-1", "S3 storage region")
+	s3BucketFl := flag.String("s3-bucket", "bb", "S3 storage bucket name")
+	httpCacheFl := flag.Bool("http-cache", os.Getenv("DEV") != "1", "Allow clients to cache pages. Disabled by default in development mode")
+	cacheSizeFl := flag.Int("cache-size", 1000, "Number of query results cached in memory, 0 disables caching")
+	cacheTTLFl := flag.Duration("cache-ttl", time.Minute, "Time query results are cached for")
+	pageSizeFl := flag.Int("page-size", forum.DefaultPageSize, "Default number of topics or messages displayed on a single page")
 	flag.Parse()
 
-	if err := tmpl.LoadTemplates(); err != nil {
+	if *pageSizeFl < forum.MinPageSize || *pageSizeFl > forum.MaxPageSize {
+		log.Fatalf("page size must be between %d and %d", forum.MinPageSize, forum.MaxPageSize)
+	}
+
+	staticfs := assets.Static()
+	if *staticsFl != "" {
+		staticfs = assets.Overlay(*staticsFl, staticfs)
+	}
+	static, err := assets.NewStaticFiles(staticfs, "/static/")
+	if err != nil {
+		log.Fatalf("cannot load static files: %s", err)
+	}
+	tmpl.AssetURL = static.URL
+
+	themes, err := assets.Themes(*themesFl)
+	if err != nil {
+		log.Fatalf("cannot load themes: %s", err)
+	}
+	var tmplThemes []*tmpl.Theme
+	for _, t := range themes {
+		tt := &tmpl.Theme{Name: t.Name, Templates: t.Templates}
+		if t.Static != nil {
+			dir := "themes/" + t.Name
+			if err := static.Mount(dir, t.Static); err != nil {
+				log.Fatalf("cannot load %q theme static files: %s", t.Name, err)
+			}
+			if t.HasCSS {
+				tt.CSS = dir + "/theme.css"
+			}
+		}
+		tmplThemes = append(tmplThemes, tt)
+	}
+
+	if dirs := assets.StaticDirs(*themesFl); dirs != nil {
+		if *staticsFl != "" {
+			dirs = append(dirs, *staticsFl)
+		}
+		if err := static.Watch(dirs...); err != nil {
+			log.Fatalf("cannot watch static files: %s", err)
+		}
+	}
+
+	if err := i18n.Load(assets.Locales()); err != nil {
+		log.Fatalf("cannot load message catalogs: %s", err)
+	}
+	if err := tmpl.LoadTemplates(assets.Templates(), *templatesFl, tmplThemes, *themeFl); err != nil {
 		log.Fatalf("cannot load templates: %s", err)
 	}
+	if dirs := assets.TemplateDirs(*themesFl); dirs != nil {
+		if *templatesFl != "" {
+			dirs = append(dirs, *templatesFl)
+		}
+		if err := tmpl.Watch(dirs...); err != nil {
+			log.Fatalf("cannot watch templates: %s", err)
+		}
+	}
 
 	ctx := context.Background()
-	ctx, err := forum.WithPG(ctx, "user=bb password=bb dbname=bb sslmode=disable")
+	ctx, err = forum.WithPG(ctx, "user=bb password=bb dbname=bb sslmode=disable")
 	if err != nil {
 		log.Fatalf("cannot connect to database: %s", err)
 	}
+	go forum.RerenderMessages(ctx)
+
+	ctx = forum.WithPageSize(ctx, *pageSizeFl)
+	ctx = forum.WithHTTPCache(ctx, *httpCacheFl)
+	if *cacheSizeFl > 0 {
+		ctx = forum.WithStoreCache(ctx, forum.NewMemoryCache(*cacheSizeFl, *cacheTTLFl))
+	}
+
+	views := forum.NewViewCounter(time.Hour)
+	ctx = forum.WithTopicViews(ctx, views)
+	go views.Run(ctx, 30*time.Second)
+
+	var blobs blob.Storage
+	if *s3EndpointFl != "" {
+		blobs, err = blob.NewS3Storage(*s3EndpointFl, *s3RegionFl, *s3BucketFl,
+			os.Getenv("S3_ACCESS_KEY"), os.Getenv("S3_SECRET_KEY"))
+	} else {
+		blobs, err = blob.NewFSStorage(*blobsFl)
+	}
+	if err != nil {
+		log.Fatalf("cannot create attachments storage: %s", err)
+	}
+	ctx = forum.WithBlobStorage(ctx, blobs)
 
 	rt := httprouter.New()
 	rt.RedirectTrailingSlash = true
@@ -59,14 +155,40 @@ func main() {
 	rt.POST("/nt/", ctxhandler(ctx, forum.HandleCreateTopic))
 	rt.GET("/nt/", ctxhandler(ctx, forum.HandleCreateTopic))
 
+	rt.POST("/preview/", ctxhandler(ctx, forum.HandlePreview))
+
 	rt.GET("/t/", ctxhandler(ctx, forum.HandleListTopics))
+	rt.GET("/t/:topicid", ctxhandler(ctx, forum.HandleListTopicMessages))
 	rt.GET("/t/:topicid/:slug/", ctxhandler(ctx, forum.HandleListTopicMessages))
 	rt.POST("/t/:topicid/:slug/", ctxhandler(ctx, forum.HandleCreateMessage))
+	rt.GET("/m/:messageid", ctxhandler(ctx, forum.HandleMessage))
+	rt.GET("/a/:hash/:name", ctxhandler(ctx, forum.HandleAttachment))
+	rt.GET("/th/:hash/:name", ctxhandler(ctx, forum.HandleAttachmentThumbnail))
 	rt.GET("/c/", ctxhandler(ctx, forum.HandleListCategories))
+	rt.GET("/u/:userid", ctxhandler(ctx, forum.HandleUserDetails))
 	rt.GET("/u/:userid/:slug/", ctxhandler(ctx, forum.HandleUserDetails))
+	rt.GET("/@:login", ctxhandler(ctx, forum.HandleUserByLogin))
+	rt.GET("/inbox/", ctxhandler(ctx, forum.HandleListNotifications))
+	rt.GET("/settings/", ctxhandler(ctx, forum.HandlePreferences))
+	rt.POST("/settings/", ctxhandler(ctx, forum.HandlePreferences))
 
-	if *staticsFl != "" {
-		rt.ServeFiles("/static/*filepath", http.Dir(*staticsFl))
+	rt.GET("/pm/", ctxhandler(ctx, forum.HandleListConversations))
+	rt.GET("/pm/:conversationid/", ctxhandler(ctx, forum.HandleListConversationMessages))
+	rt.POST("/pm/:conversationid/", ctxhandler(ctx, forum.HandleCreatePrivateMessage))
+	rt.POST("/npm/", ctxhandler(ctx, forum.HandleCreateConversation))
+	rt.GET("/npm/", ctxhandler(ctx, forum.HandleCreateConversation))
+
+	rt.Handler("GET", "/static/*filepath", static)
+	rt.Handler("HEAD", "/static/*filepath", static)
+
+	if *debugAddrFl != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/debug/vars", expvar.Handler())
+			if err := http.ListenAndServe(*debugAddrFl, mux); err != nil {
+				log.Printf("debug HTTP server error: %s", err)
+			}
+		}()
 	}
 
 	log.Println("running server")
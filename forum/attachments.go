@@ -0,0 +1,295 @@
+package forum
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/husio/bb/blob"
+	"github.com/husio/bb/i18n"
+	"github.com/husio/bb/tmpl"
+	"golang.org/x/image/draw"
+	"golang.org/x/net/context"
+)
+
+const (
+	maxAttachmentSize = 5 << 20
+	maxAttachments    = 10
+	// maxUploadSize limits the size of the whole form submission
+	maxUploadSize = maxAttachments*maxAttachmentSize + 1<<20
+
+	thumbnailSize = 240
+	// maxThumbnailPixels limits dimensions of images that thumbnails are
+	// created for. A small file can declare a huge image, that would take
+	// gigabytes of memory to decode.
+	maxThumbnailPixels = 25 << 20
+)
+
+// attachmentTypes is the list of all content types that can be attached to
+// a message.
+var attachmentTypes = map[string]bool{
+	"image/png":                 true,
+	"image/jpeg":                true,
+	"image/gif":                 true,
+	"text/plain; charset=utf-8": true,
+	"application/pdf":           true,
+	"application/zip":           true,
+	"application/x-gzip":        true,
+}
+
+func WithBlobStorage(ctx context.Context, s blob.Storage) context.Context {
+	return context.WithValue(ctx, "blob:storage", s)
+}
+
+func BlobStorage(ctx context.Context) blob.Storage {
+	return ctx.Value("blob:storage").(blob.Storage)
+}
+
+// upload is a validated file submitted together with a message.
+type upload struct {
+	Name        string
+	ContentType string
+	Hash        string
+	Data        []byte
+}
+
+// readUploads return all files submitted with the form as "attachment". If
+// any of the files is not valid, description of the problem is returned.
+// hasUploads return true if any file was attached to the submitted form.
+func hasUploads(r *http.Request) bool {
+	return r.MultipartForm != nil && len(r.MultipartForm.File["attachment"]) > 0
+}
+
+func readUploads(r *http.Request) ([]*upload, *i18n.Message, error) {
+	if r.MultipartForm == nil {
+		return nil, nil, nil
+	}
+	files := r.MultipartForm.File["attachment"]
+	if len(files) > maxAttachments {
+		return nil, i18n.M("Not more than %d files can be attached", maxAttachments), nil
+	}
+
+	var uploads []*upload
+	for _, fh := range files {
+		name := attachmentName(fh.Filename)
+		fd, err := fh.Open()
+		if err != nil {
+			return nil, nil, err
+		}
+		data, err := ioutil.ReadAll(io.LimitReader(fd, maxAttachmentSize+1))
+		fd.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(data) == 0 {
+			// browsers submit empty file input when nothing was selected
+			continue
+		}
+		if len(data) > maxAttachmentSize {
+			return nil, i18n.M("File %q is bigger than %d MB", name, maxAttachmentSize>>20), nil
+		}
+		ctype := http.DetectContentType(data)
+		if !attachmentTypes[ctype] {
+			return nil, i18n.M("File %q is of not supported type", name), nil
+		}
+		sum := sha256.Sum256(data)
+		uploads = append(uploads, &upload{
+			Name:        name,
+			ContentType: ctype,
+			Hash:        hex.EncodeToString(sum[:]),
+			Data:        data,
+		})
+	}
+	return uploads, nil, nil
+}
+
+// attachmentName return file name that is safe to use in URL path.
+func attachmentName(filename string) string {
+	name := path.Base(strings.Replace(filename, "\\", "/", -1))
+	name = strings.Trim(attachmentNamerx.ReplaceAllString(name, "-"), "-.")
+	if len(name) > 100 {
+		name = name[len(name)-100:]
+	}
+	if name == "" {
+		return "file"
+	}
+	return name
+}
+
+var attachmentNamerx = regexp.MustCompile(`[^\w.-]+`)
+
+// linkAttachments return content with all "attachment:<name>" references to
+// submitted files replaced with attachment URL. This is how attachments are
+// inserted into the markdown body, for example:
+//
+//	![screenshot](attachment:screenshot.png)
+func linkAttachments(content string, uploads []*upload) string {
+	if len(uploads) == 0 {
+		return content
+	}
+	return attachmentRefrx.ReplaceAllStringFunc(content, func(ref string) string {
+		name := strings.TrimPrefix(ref, "attachment:")
+		for _, u := range uploads {
+			if u.Name == name {
+				return attachmentURL(u.Hash, u.Name)
+			}
+		}
+		return ref
+	})
+}
+
+var attachmentRefrx = regexp.MustCompile(`attachment:[\w.-]+`)
+
+func attachmentURL(hash, name string) string {
+	return "/a/" + hash + "/" + name
+}
+
+// saveAttachments store uploaded files and attach them to given message.
+// Files with the same content are stored only once.
+func saveAttachments(ctx context.Context, s *store, m *Message, uploads []*upload) error {
+	for _, u := range uploads {
+		a, err := s.AttachmentByHash(u.Hash)
+		switch err {
+		case nil:
+		case ErrNotFound:
+			if a, err = storeAttachment(ctx, s, u, m.Created); err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+		if err := s.AddMessageAttachment(m.MessageID, a.AttachmentID, u.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func storeAttachment(ctx context.Context, s *store, u *upload, now time.Time) (*Attachment, error) {
+	blobs := BlobStorage(ctx)
+	if err := blobs.Put(u.Hash, u.ContentType, bytes.NewReader(u.Data)); err != nil {
+		return nil, fmt.Errorf("cannot store attachment: %s", err)
+	}
+	var hasThumb bool
+	if thumb, ok := thumbnail(u.Data); ok {
+		if err := blobs.Put(u.Hash+".thumb", thumbnailType(u.ContentType), bytes.NewReader(thumb)); err != nil {
+			return nil, fmt.Errorf("cannot store thumbnail: %s", err)
+		}
+		hasThumb = true
+	}
+	return s.CreateAttachment(u.Hash, u.ContentType, uint(len(u.Data)), hasThumb, now)
+}
+
+// thumbnail return scaled down version of given image. False is returned if
+// data is not an image, the image is too big to be decoded or small enough
+// to be used as a thumbnail.
+func thumbnail(data []byte) ([]byte, bool) {
+	conf, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, false
+	}
+	if int64(conf.Width)*int64(conf.Height) > maxThumbnailPixels {
+		return nil, false
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, false
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= thumbnailSize && h <= thumbnailSize {
+		return nil, false
+	}
+	if w > h {
+		w, h = thumbnailSize, h*thumbnailSize/w
+	} else {
+		w, h = w*thumbnailSize/h, thumbnailSize
+	}
+	if w == 0 {
+		w = 1
+	}
+	if h == 0 {
+		h = 1
+	}
+	thumb := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.ApproxBiLinear.Scale(thumb, thumb.Bounds(), img, b, draw.Over, nil)
+
+	var out bytes.Buffer
+	if format == "jpeg" {
+		err = jpeg.Encode(&out, thumb, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&out, thumb)
+	}
+	if err != nil {
+		return nil, false
+	}
+	return out.Bytes(), true
+}
+
+// thumbnailType return content type of thumbnail created for image of given
+// type.
+func thumbnailType(contentType string) string {
+	if contentType == "image/jpeg" {
+		return "image/jpeg"
+	}
+	return "image/png"
+}
+
+func HandleAttachment(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	serveAttachment(ctx, w, r, false)
+}
+
+func HandleAttachmentThumbnail(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	serveAttachment(ctx, w, r, true)
+}
+
+func serveAttachment(ctx context.Context, w http.ResponseWriter, r *http.Request, thumb bool) {
+	a, err := NewStore(ctx, DB(ctx)).AttachmentByHash(param(ctx, "hash"))
+	if err == ErrNotFound || (err == nil && thumb && !a.Thumbnail) {
+		tmpl.Render404(w, "Attachment does not exist")
+		return
+	}
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+
+	key, ctype := a.Hash, a.ContentType
+	if thumb {
+		key, ctype = a.Hash+".thumb", thumbnailType(a.ContentType)
+	}
+	fd, err := BlobStorage(ctx).Get(key)
+	if err == blob.ErrNotFound {
+		tmpl.Render404(w, "Attachment does not exist")
+		return
+	}
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+	defer fd.Close()
+
+	h := w.Header()
+	h.Set("Content-Type", ctype)
+	h.Set("X-Content-Type-Options", "nosniff")
+	// content is addressed by its hash and never changes
+	h.Set("Cache-Control", "public, max-age=31536000")
+	if a.IsImage() {
+		h.Set("Content-Disposition", "inline")
+	} else {
+		h.Set("Content-Disposition", "attachment")
+	}
+	io.Copy(w, fd)
+}
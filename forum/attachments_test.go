@@ -0,0 +1,46 @@
+package forum
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func TestThumbnail(t *testing.T) {
+	var small, big bytes.Buffer
+	if err := png.Encode(&small, image.NewGray(image.Rect(0, 0, 100, 50))); err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(&big, image.NewGray(image.Rect(0, 0, 960, 480))); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := thumbnail(small.Bytes()); ok {
+		t.Error("thumbnail created for small image")
+	}
+	if _, ok := thumbnail([]byte("not an image")); ok {
+		t.Error("thumbnail created for non image")
+	}
+	thumb, ok := thumbnail(big.Bytes())
+	if !ok {
+		t.Fatal("thumbnail not created")
+	}
+	conf, err := png.DecodeConfig(bytes.NewReader(thumb))
+	if err != nil {
+		t.Fatalf("cannot decode thumbnail: %s", err)
+	}
+	if conf.Width != thumbnailSize || conf.Height != thumbnailSize/2 {
+		t.Errorf("want %dx%d thumbnail, got %dx%d", thumbnailSize, thumbnailSize/2, conf.Width, conf.Height)
+	}
+}
+
+func TestThumbnailTooBig(t *testing.T) {
+	var b bytes.Buffer
+	if err := png.Encode(&b, image.NewGray(image.Rect(0, 0, 5121, 5120))); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := thumbnail(b.Bytes()); ok {
+		t.Fatal("thumbnail created for image bigger than the limit")
+	}
+}
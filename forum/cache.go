@@ -0,0 +1,206 @@
+package forum
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"expvar"
+	"fmt"
+	"log"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Cache keeps results of the most frequent store queries. Values are
+// serialized, so that the cache can be kept outside of the process, for
+// example in memcached. Every value should expire after some time, because
+// not all writes invalidate cached results. Implementations must be safe for
+// concurrent use.
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+	Delete(keys ...string)
+}
+
+// WithStoreCache return context with given cache used by all stores.
+func WithStoreCache(ctx context.Context, c Cache) context.Context {
+	return context.WithValue(ctx, "store:cache", c)
+}
+
+// StoreCache return cache used by stores or nil if queries are not cached.
+func StoreCache(ctx context.Context) Cache {
+	c, _ := ctx.Value("store:cache").(Cache)
+	return c
+}
+
+// cacheMetrics count cache hits and misses of all stores. They are exposed
+// together with other expvar variables.
+var cacheMetrics = expvar.NewMap("store_cache")
+
+const (
+	categoriesCacheKey = "categories"
+
+	// topicsVersionCacheKey is part of the key of every cached topic list.
+	// Changing the version invalidates all of them at once.
+	topicsVersionCacheKey = "topics:version"
+)
+
+// cached load the value from the cache into dest. If the value is not
+// cached, it is loaded into dest by the load function and cached.
+func (s *store) cached(key string, dest interface{}, load func() error) error {
+	if s.cache == nil {
+		return load()
+	}
+	if raw, ok := s.cache.Get(key); ok {
+		err := gob.NewDecoder(bytes.NewReader(raw)).Decode(dest)
+		if err == nil {
+			cacheMetrics.Add("hits", 1)
+			return nil
+		}
+		log.Printf("cannot decode cached %q: %s", key, err)
+		// value might be decoded partially
+		v := reflect.ValueOf(dest).Elem()
+		v.Set(reflect.Zero(v.Type()))
+	}
+	cacheMetrics.Add("misses", 1)
+
+	if err := load(); err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(dest); err != nil {
+		log.Printf("cannot encode %q for cache: %s", key, err)
+		return nil
+	}
+	s.cache.Set(key, b.Bytes())
+	return nil
+}
+
+// topicsCacheKey return cache key of the topic list described by given
+// values. Returned key is valid until topics are invalidated.
+func (s *store) topicsCacheKey(values ...interface{}) string {
+	if s.cache == nil {
+		return ""
+	}
+	version, ok := s.cache.Get(topicsVersionCacheKey)
+	if !ok {
+		version = newCacheVersion()
+		s.cache.Set(topicsVersionCacheKey, version)
+	}
+	h := sha256.New()
+	for _, v := range values {
+		fmt.Fprintf(h, "%+v\x00", v)
+	}
+	return "topics:" + string(version) + ":" + hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+func newCacheVersion() []byte {
+	return []byte(strconv.FormatInt(time.Now().UnixNano(), 36))
+}
+
+// invalidateTopics remove all cached topic lists. When the store is used
+// within a transaction, other requests can cache old values until the
+// transaction is committed, so flushInvalidated must be called after the
+// commit.
+func (s *store) invalidateTopics() {
+	if s.cache == nil {
+		return
+	}
+	s.cache.Set(topicsVersionCacheKey, newCacheVersion())
+	s.cache.Delete(categoriesCacheKey)
+	s.invalidated = true
+}
+
+// flushInvalidated invalidate again all cached values that were invalidated
+// by the store.
+func (s *store) flushInvalidated() {
+	if s.invalidated {
+		s.invalidateTopics()
+		s.invalidated = false
+	}
+}
+
+// MemoryCache is Cache keeping values in the process memory. It holds a
+// limited number of values, each for limited time. When full, the least
+// recently used value is removed.
+type MemoryCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	lru     *list.List // most recently used first
+}
+
+type memoryCacheEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewMemoryCache return cache holding up to given number of values, each
+// for given time.
+func NewMemoryCache(size int, ttl time.Duration) *MemoryCache {
+	return &MemoryCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (c *MemoryCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*memoryCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(el)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return entry.value, true
+}
+
+func (c *MemoryCache) Set(key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Now().Add(c.ttl)
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*memoryCacheEntry)
+		entry.value = value
+		entry.expires = expires
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&memoryCacheEntry{key: key, value: value, expires: expires})
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *MemoryCache) Delete(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		if el, ok := c.entries[key]; ok {
+			c.remove(el)
+		}
+	}
+}
+
+func (c *MemoryCache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*memoryCacheEntry).key)
+}
@@ -0,0 +1,153 @@
+package forum
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheEviction(t *testing.T) {
+	c := NewMemoryCache(3, time.Minute)
+	c.Set("a", []byte("1"))
+	c.Set("b", []byte("2"))
+	c.Set("c", []byte("3"))
+	// reading "a" makes "b" the least recently used
+	c.Get("a")
+	c.Set("d", []byte("4"))
+	// updating "c" makes "a" the least recently used
+	c.Set("c", []byte("33"))
+	c.Set("e", []byte("5"))
+
+	want := map[string]string{
+		"a": "",
+		"b": "",
+		"c": "33",
+		"d": "4",
+		"e": "5",
+	}
+	for key, value := range want {
+		got, ok := c.Get(key)
+		if ok != (value != "") {
+			t.Errorf("%q: want cached %v, got %v", key, value != "", ok)
+		}
+		if string(got) != value {
+			t.Errorf("%q: want %q, got %q", key, value, got)
+		}
+	}
+}
+
+func TestMemoryCacheExpiration(t *testing.T) {
+	c := NewMemoryCache(10, 20*time.Millisecond)
+	c.Set("a", []byte("1"))
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("value expired too early")
+	}
+	time.Sleep(30 * time.Millisecond)
+	c.Set("b", []byte("2"))
+	if _, ok := c.Get("a"); ok {
+		t.Error("expired value returned")
+	}
+	if _, ok := c.Get("b"); !ok {
+		t.Error("value set after expiration is missing")
+	}
+	if n := c.lru.Len(); n != 1 {
+		t.Errorf("want 1 value kept, got %d", n)
+	}
+}
+
+func TestMemoryCacheDelete(t *testing.T) {
+	c := NewMemoryCache(10, time.Minute)
+	c.Set("a", []byte("1"))
+	c.Set("b", []byte("2"))
+	c.Delete("a", "unknown")
+	if _, ok := c.Get("a"); ok {
+		t.Error("deleted value returned")
+	}
+	if _, ok := c.Get("b"); !ok {
+		t.Error("not deleted value is missing")
+	}
+}
+
+func TestTopicsCacheInvalidation(t *testing.T) {
+	cache := NewMemoryCache(10, time.Minute)
+	s := &store{cache: cache}
+
+	var loads int
+	load := func(s *store) {
+		var topics []*SortedTopic
+		err := s.cached(s.topicsCacheKey("new", 10), &topics, func() error {
+			loads++
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	load(s)
+	load(s)
+	if loads != 1 {
+		t.Fatalf("want cached result, loaded %d times", loads)
+	}
+
+	s.invalidateTopics()
+	load(s)
+	if loads != 2 {
+		t.Fatalf("want result loaded after invalidation, loaded %d times", loads)
+	}
+
+	// old result cached by another store before the transaction is
+	// committed must be invalidated by the flush
+	tx := &store{cache: cache}
+	tx.invalidateTopics()
+	load(s)
+	tx.flushInvalidated()
+	load(s)
+	if loads != 4 {
+		t.Fatalf("want result loaded after flush, loaded %d times", loads)
+	}
+	if tx.invalidated {
+		t.Fatal("store invalidated after flush")
+	}
+}
+
+func TestCachedSortedTopic(t *testing.T) {
+	now := time.Date(2016, 3, 1, 12, 34, 56, 0, time.UTC)
+	want := []*SortedTopic{
+		{
+			TopicWithUserCategory: TopicWithUserCategory{
+				Topic: Topic{
+					TopicID:    42,
+					Title:      "Cached topic",
+					AuthorID:   7,
+					CategoryID: 3,
+					Created:    now,
+					Updated:    now.Add(time.Hour),
+					Replies:    5,
+					Views:      100,
+				},
+				User:     User{UserID: 7, Login: "bob"},
+				Category: Category{CategoryID: 3, Name: "General", TopicsCount: 1, Color: 0xff0000},
+			},
+			SortKey: "2016-03-01 13:34:56+00",
+		},
+	}
+
+	s := &store{cache: NewMemoryCache(10, time.Minute)}
+	for i := 0; i < 2; i++ {
+		var got []*SortedTopic
+		err := s.cached("topics", &got, func() error {
+			if i != 0 {
+				t.Fatal("result not cached")
+			}
+			got = want
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(want, got) {
+			t.Fatalf("want %+v, got %+v", want[0], got[0])
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package forum
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/husio/bb/i18n"
+	"github.com/husio/bb/tmpl"
+	"golang.org/x/net/context"
+)
+
+const maxConversationParticipants = 20
+
+func HandleCreateConversation(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	uid, ok := CurrentUserID(r)
+	if !ok {
+		// TODO - redirect to authentication page, but remember form content
+		tmpl.Render500(w, errors.New("not implemented"))
+		return
+	}
+	var c struct {
+		*Header    `json:"header"`
+		To         string        `json:"to"`
+		ToErr      *i18n.Message `json:"to_err"`
+		Subject    string        `json:"subject"`
+		SubjectErr *i18n.Message `json:"subject_err"`
+		Content    string        `json:"content"`
+		ContentErr *i18n.Message `json:"content_err"`
+	}
+
+	if r.Method == "GET" {
+		var err error
+		if c.Header, err = loadHeader(NewStore(ctx, DB(ctx)), r); err != nil {
+			tmpl.Render500(w, err)
+		} else {
+			c.To = r.URL.Query().Get("to")
+			tmpl.Render(w, http.StatusOK, "page_create_conversation", c)
+		}
+		return
+	}
+
+	if err := r.ParseMultipartForm(2 << 20); err != nil {
+		tmpl.Render400(w, err.Error())
+		return
+	}
+	c.To = strings.TrimSpace(r.FormValue("to"))
+	c.Subject = strings.TrimSpace(r.FormValue("subject"))
+	c.Content = strings.TrimSpace(r.FormValue("content"))
+
+	if len(c.Subject) < 3 {
+		c.SubjectErr = i18n.M("Subject must be at least 3 characters long")
+	}
+	if len(c.Subject) > 200 {
+		c.SubjectErr = i18n.M("Subject must not be longer than 200 characters")
+	}
+	if len(c.Content) < 3 {
+		c.ContentErr = i18n.M("Content must be at least 3 characters long")
+	}
+	if len(c.Content) > 10000 {
+		c.ContentErr = i18n.M("Content must be shorter than 10000 characters")
+	}
+
+	tx, err := DB(ctx).Beginx()
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+	defer tx.Rollback()
+	store := NewStore(ctx, tx)
+
+	logins := strings.FieldsFunc(c.To, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+	recipients, err := store.UsersByLogin(logins)
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+	if missing := missingLogins(logins, recipients); len(missing) != 0 {
+		c.ToErr = i18n.M("Unknown user: %s", strings.Join(missing, ", "))
+	}
+	var participants []uint
+	for _, u := range recipients {
+		if uint(u.UserID) != uid {
+			participants = append(participants, uint(u.UserID))
+		}
+	}
+	if len(participants) == 0 && c.ToErr == nil {
+		c.ToErr = i18n.M("At least one recipient is required")
+	}
+	if len(participants) >= maxConversationParticipants {
+		c.ToErr = i18n.M("Conversation must not have more than %d participants", maxConversationParticipants)
+	}
+
+	if c.ToErr != nil || c.SubjectErr != nil || c.ContentErr != nil {
+		if c.Header, err = loadHeader(store, r); err != nil {
+			tmpl.Render500(w, err)
+		} else {
+			tmpl.Render(w, http.StatusBadRequest, "page_create_conversation", c)
+		}
+		return
+	}
+
+	now := time.Now()
+	conv, err := store.CreateConversation(c.Subject, now)
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+	if err := store.AddConversationParticipant(conv.ConversationID, uid, now); err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+	for _, pid := range participants {
+		if err := store.AddConversationParticipant(conv.ConversationID, pid, time.Time{}); err != nil {
+			tmpl.Render500(w, err)
+			return
+		}
+	}
+	if _, err := store.CreatePrivateMessage(conv.ConversationID, uid, c.Content, now); err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+	curl := fmt.Sprintf("/pm/%d/", conv.ConversationID)
+	http.Redirect(w, r, curl, http.StatusFound)
+}
+
+// missingLogins return all logins that do not belong to any of given users.
+func missingLogins(logins []string, users []*User) []string {
+	found := make(map[string]bool)
+	for _, u := range users {
+		found[u.Login] = true
+	}
+	var missing []string
+	for _, login := range logins {
+		if !found[login] {
+			missing = append(missing, login)
+		}
+	}
+	return missing
+}
+
+func HandleListConversations(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	uid, ok := CurrentUserID(r)
+	if !ok {
+		// TODO - redirect to authentication page
+		tmpl.Render500(w, errors.New("not implemented"))
+		return
+	}
+
+	store := NewStore(ctx, DB(ctx))
+
+	total, err := store.ConversationsCount(uid)
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+	sizes, err := loadPageSizes(ctx, store, r)
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+	p := NewPaginator(r.URL.Query(), total, sizes.Topics)
+	convs, err := store.Conversations(uid, p.Offset(), p.Limit())
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+
+	header, err := loadHeader(store, r)
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+
+	c := struct {
+		*Header       `json:"header"`
+		Conversations []*ConversationWithUnread `json:"conversations"`
+		Paginator     *Paginator                `json:"paginator"`
+		// MessagesPageSize is required to link the last page of conversations
+		MessagesPageSize int `json:"messages_page_size"`
+	}{
+		Header:           header,
+		Conversations:    convs,
+		Paginator:        p,
+		MessagesPageSize: sizes.Messages,
+	}
+	tmpl.Render(w, http.StatusOK, "page_conversation_list", c)
+}
+
+func HandleListConversationMessages(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	uid, ok := CurrentUserID(r)
+	if !ok {
+		// TODO - redirect to authentication page
+		tmpl.Render500(w, errors.New("not implemented"))
+		return
+	}
+
+	tx, err := DB(ctx).Beginx()
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	store := NewStore(ctx, tx)
+
+	convID, err := strconv.Atoi(param(ctx, "conversationid"))
+	if err != nil || convID < 0 {
+		tmpl.Render404(w, "Conversation does not exist")
+		return
+	}
+	// conversation is reported as missing when user is not participating, so
+	// that it is not possible to guess which conversations exist
+	conv, err := store.ConversationForParticipant(uint(convID), uid)
+	if err == ErrNotFound {
+		tmpl.Render404(w, "Conversation does not exist")
+		return
+	}
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+
+	participants, err := store.ConversationParticipants(conv.ConversationID)
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+
+	sizes, err := loadPageSizes(ctx, store, r)
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+	p := NewPaginator(r.URL.Query(), int(conv.Messages), sizes.Messages)
+	messages, err := store.ConversationMessages(conv.ConversationID, p.Offset(), p.Limit())
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+	if len(messages) != 0 {
+		last := messages[len(messages)-1].Created
+		if err := store.MarkConversationRead(conv.ConversationID, uid, last); err != nil {
+			tmpl.Render500(w, err)
+			return
+		}
+	}
+
+	header, err := loadHeader(store, r)
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+
+	c := struct {
+		*Header      `json:"header"`
+		Conversation *Conversation             `json:"conversation"`
+		Participants []*User                   `json:"participants"`
+		Messages     []*PrivateMessageWithUser `json:"messages"`
+		Paginator    *Paginator                `json:"paginator"`
+	}{
+		Header:       header,
+		Conversation: conv,
+		Participants: participants,
+		Messages:     messages,
+		Paginator:    p,
+	}
+	tmpl.Render(w, http.StatusOK, "page_conversation", c)
+}
+
+func HandleCreatePrivateMessage(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	uid, ok := CurrentUserID(r)
+	if !ok {
+		// TODO - redirect to authentication page, but remember form content
+		tmpl.Render500(w, errors.New("not implemented"))
+		return
+	}
+
+	convID, err := strconv.Atoi(param(ctx, "conversationid"))
+	if err != nil || convID < 0 {
+		tmpl.Render404(w, "Conversation does not exist")
+		return
+	}
+
+	content := strings.TrimSpace(r.FormValue("content"))
+	if len(content) < 3 {
+		tmpl.Render400(w, "Message too short")
+		return
+	}
+	if len(content) > 20000 {
+		tmpl.Render400(w, "Message too long")
+		return
+	}
+
+	tx, err := DB(ctx).Beginx()
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	store := NewStore(ctx, tx)
+
+	conv, err := store.ConversationForParticipant(uint(convID), uid)
+	if err != nil {
+		if err == ErrNotFound {
+			tmpl.Render404(w, "Conversation does not exist")
+		} else {
+			tmpl.Render500(w, err)
+		}
+		return
+	}
+
+	now := time.Now()
+	m, err := store.CreatePrivateMessage(conv.ConversationID, uid, content, now)
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+	if err := store.MarkConversationRead(conv.ConversationID, uid, now); err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+
+	sizes, err := loadPageSizes(ctx, store, r)
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+
+	conv.Messages++
+	murl := fmt.Sprintf(
+		"/pm/%d/?page=%d#pm%d",
+		conv.ConversationID, conv.Pages(sizes.Messages), m.PrivateMessageID)
+	http.Redirect(w, r, murl, http.StatusFound)
+}
@@ -2,27 +2,28 @@ package forum
 
 import (
 	"fmt"
-	"math"
-	"regexp"
+	"html/template"
 	"strings"
 	"time"
+
+	"github.com/husio/bb/tmpl"
 )
 
 type User struct {
-	UserID uint64 `db:"user_id"`
-	Login  string `db:"login"`
+	UserID uint64 `db:"user_id" json:"user_id"`
+	Login  string `db:"login" json:"login"`
 }
 
 func (u *User) Slug() string {
-	return slugify(u.Login)
+	return slugOr(u.Login, "user")
 }
 
 type Category struct {
-	CategoryID  uint   `db:"category_id"`
-	Name        string `db:"name"`
-	Description string `db:"description"`
-	TopicsCount uint   `db:"topics_count"`
-	Color       uint   `db:"color"`
+	CategoryID  uint   `db:"category_id" json:"category_id"`
+	Name        string `db:"name" json:"name"`
+	Description string `db:"description" json:"description"`
+	TopicsCount uint   `db:"topics_count" json:"topics_count"`
+	Color       uint   `db:"color" json:"color"`
 }
 
 func (c *Category) ColorHex() string {
@@ -34,54 +35,181 @@ func (c *Category) SetColorHex(x string) {
 }
 
 func (c *Category) Slug() string {
-	return slugify(c.Name)
+	return slugOr(c.Name, "category")
 }
 
 type Topic struct {
-	TopicID    uint      `db:"topic_id"`
-	Title      string    `db:"title"`
-	AuthorID   uint      `db:"author_id"`
-	CategoryID uint      `db:"category_id"`
-	Created    time.Time `db:"created"`
-	Updated    time.Time `db:"updated"`
-	Replies    uint      `db:"replies"`
+	TopicID    uint      `db:"topic_id" json:"topic_id"`
+	Title      string    `db:"title" json:"title"`
+	AuthorID   uint      `db:"author_id" json:"author_id"`
+	CategoryID uint      `db:"category_id" json:"category_id"`
+	Created    time.Time `db:"created" json:"created"`
+	Updated    time.Time `db:"updated" json:"updated"`
+	Replies    uint      `db:"replies" json:"replies"`
+	Views      uint      `db:"views" json:"views"`
 }
 
 func (t *Topic) Slug() string {
-	return slugify(t.Title)
+	return slugOr(t.Title, "topic")
 }
 
-func (t *Topic) Pages() uint {
-	return uint(math.Ceil(float64(t.Replies+1) / float64(PageSize)))
+// Pages return number of pages required to display all topic messages.
+func (t *Topic) Pages(pageSize int) uint {
+	return pageCount(t.Replies+1, pageSize)
 }
 
 type TopicWithUserCategory struct {
-	Topic
-	User
-	Category
+	Topic    `json:"topic"`
+	User     `json:"user"`
+	Category `json:"category"`
 }
 
 type Message struct {
-	MessageID uint      `db:"message_id"`
-	AuthorID  uint      `db:"author_id"`
-	TopicID   uint      `db:"topic_id"`
-	Content   string    `db:"content"`
-	Created   time.Time `db:"created"`
+	MessageID      uint      `db:"message_id" json:"message_id"`
+	AuthorID       uint      `db:"author_id" json:"author_id"`
+	TopicID        uint      `db:"topic_id" json:"topic_id"`
+	Content        string    `db:"content" json:"content"`
+	ContentHTML    string    `db:"content_html" json:"-"`
+	ContentVersion uint      `db:"content_version" json:"-"`
+	ReplyTo        *uint     `db:"reply_to_message_id" json:"reply_to_message_id"`
+	Created        time.Time `db:"created" json:"created"`
+}
+
+// HTML return rendered message content. Pre-rendered content is used only if
+// it was created by the current version of markdown renderer.
+func (m *Message) HTML() template.HTML {
+	if m.ContentVersion == tmpl.MarkdownVersion {
+		return template.HTML(m.ContentHTML)
+	}
+	return tmpl.Markdown(m.Content)
 }
 
 type MessageWithUser struct {
-	Message
-	User
+	Message `json:"message"`
+	User    `json:"user"`
+}
+
+// MessagePosition is the position of the message within its topic.
+type MessagePosition struct {
+	MessageID uint `db:"message_id" json:"message_id"`
+	Position  uint `db:"position" json:"position"`
 }
 
-const maxSlugLen = 140
+// Page return number of topic page that message is displayed on.
+func (p *MessagePosition) Page(pageSize int) uint {
+	return pageCount(p.Position, pageSize)
+}
 
-func slugify(s string) string {
-	s = slugrx.ReplaceAllString(strings.ToLower(s), "-")
-	if len(s) > maxSlugLen {
-		s = s[:maxSlugLen]
+// Attachment is a file that can be attached to any number of messages. Every
+// file is identified by the hash of its content, so that the same file is
+// stored only once.
+type Attachment struct {
+	AttachmentID uint      `db:"attachment_id" json:"attachment_id"`
+	Hash         string    `db:"hash" json:"hash"`
+	ContentType  string    `db:"content_type" json:"content_type"`
+	Size         uint      `db:"size" json:"size"`
+	Thumbnail    bool      `db:"thumbnail" json:"thumbnail"`
+	Created      time.Time `db:"created" json:"created"`
+}
+
+func (a *Attachment) IsImage() bool {
+	return strings.HasPrefix(a.ContentType, "image/")
+}
+
+// MessageAttachment is an attachment together with the name it was uploaded
+// with.
+type MessageAttachment struct {
+	Attachment
+	MessageID uint   `db:"message_id" json:"message_id"`
+	Name      string `db:"name" json:"name"`
+}
+
+func (a *MessageAttachment) URL() string {
+	return attachmentURL(a.Hash, a.Name)
+}
+
+func (a *MessageAttachment) ThumbnailURL() string {
+	if !a.Thumbnail {
+		return a.URL()
 	}
-	return strings.Trim(s, "-")
+	return "/th/" + a.Hash + "/" + a.Name
+}
+
+func (a *MessageAttachment) SizeKB() uint {
+	return (a.Size + 1023) / 1024
+}
+
+const (
+	NotificationMention = "mention"
+	NotificationReply   = "reply"
+	NotificationQuote   = "quote"
+)
+
+type Notification struct {
+	NotificationID uint      `db:"notification_id" json:"notification_id"`
+	UserID         uint      `db:"user_id" json:"user_id"`
+	ActorID        uint      `db:"actor_id" json:"actor_id"`
+	MessageID      uint      `db:"message_id" json:"message_id"`
+	Kind           string    `db:"kind" json:"kind"`
+	Created        time.Time `db:"created" json:"created"`
+	Seen           bool      `db:"seen" json:"seen"`
+}
+
+// NotificationWithContext is notification with all information required to
+// display it and to link to notification's message.
+type NotificationWithContext struct {
+	Notification
+	ActorLogin string `db:"actor_login" json:"actor_login"`
+	TopicID    uint   `db:"topic_id" json:"topic_id"`
+	TopicTitle string `db:"topic_title" json:"topic_title"`
+}
+
+func (n *NotificationWithContext) ActorSlug() string {
+	return slugOr(n.ActorLogin, "user")
+}
+
+func (n *NotificationWithContext) TopicSlug() string {
+	return slugOr(n.TopicTitle, "topic")
+}
+
+type Conversation struct {
+	ConversationID uint      `db:"conversation_id" json:"conversation_id"`
+	Subject        string    `db:"subject" json:"subject"`
+	Created        time.Time `db:"created" json:"created"`
+	Updated        time.Time `db:"updated" json:"updated"`
+	Messages       uint      `db:"messages_count" json:"messages_count"`
 }
 
-var slugrx = regexp.MustCompile("[^a-z0-9-]+")
+func (c *Conversation) Pages(pageSize int) uint {
+	return pageCount(c.Messages, pageSize)
+}
+
+// ConversationWithUnread is conversation as seen by one of the participants.
+type ConversationWithUnread struct {
+	Conversation
+	Unread uint `db:"unread" json:"unread"` // messages written by others since last read
+}
+
+type PrivateMessage struct {
+	PrivateMessageID uint      `db:"private_message_id" json:"private_message_id"`
+	ConversationID   uint      `db:"conversation_id" json:"conversation_id"`
+	AuthorID         uint      `db:"author_id" json:"author_id"`
+	Content          string    `db:"content" json:"content"`
+	Created          time.Time `db:"created" json:"created"`
+}
+
+type PrivateMessageWithUser struct {
+	PrivateMessage `json:"message"`
+	User           `json:"user"`
+}
+
+// Preferences are the settings chosen by the user. Zero value of any of the
+// settings means that the deployment default is used.
+type Preferences struct {
+	UserID           uint   `db:"user_id" json:"user_id"`
+	TopicsPageSize   int    `db:"topics_page_size" json:"topics_page_size"`
+	MessagesPageSize int    `db:"messages_page_size" json:"messages_page_size"`
+	Theme            string `db:"theme" json:"theme"`
+	Locale           string `db:"locale" json:"locale"`
+	Timezone         string `db:"timezone" json:"timezone"`
+}
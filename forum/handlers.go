@@ -1,16 +1,18 @@
 package forum
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
-	"os"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/husio/bb/i18n"
 	"github.com/husio/bb/tmpl"
 	"github.com/julienschmidt/httprouter"
 
@@ -34,17 +36,27 @@ func HandleCreateTopic(ctx context.Context, w http.ResponseWriter, r *http.Reque
 		return
 	}
 	var c struct {
-		Title       string
-		TitleErr    string
-		Category    uint
-		CategoryErr string
-		Categories  []*Category
-		Content     string
-		ContentErr  string
+		*Header     `json:"header"`
+		Title       string        `json:"title"`
+		TitleErr    *i18n.Message `json:"title_err"`
+		Category    uint          `json:"category"`
+		CategoryErr *i18n.Message `json:"category_err"`
+		Categories  []*Category   `json:"categories"`
+		Tags        string        `json:"tags"`
+		TagsErr     *i18n.Message `json:"tags_err"`
+		Content     string        `json:"content"`
+		ContentErr  *i18n.Message `json:"content_err"`
+		Attachments *i18n.Message `json:"attachments"`
+		Preview     bool          `json:"preview"`
+		// Reattach is true if files attached to previewed form must be
+		// selected again, because they are not kept
+		Reattach bool `json:"reattach"`
 	}
 
 	if r.Method == "GET" {
-		if cats, err := NewStore(DB(ctx)).Categories(); err != nil {
+		if cats, err := NewStore(ctx, DB(ctx)).Categories(); err != nil {
+			tmpl.Render500(w, err)
+		} else if c.Header, err = loadHeader(NewStore(ctx, DB(ctx)), r); err != nil {
 			tmpl.Render500(w, err)
 		} else {
 			c.Categories = cats
@@ -53,37 +65,67 @@ func HandleCreateTopic(ctx context.Context, w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
 	if err := r.ParseMultipartForm(2 << 20); err != nil {
 		tmpl.Render400(w, err.Error())
 		return
 	}
 	c.Content = strings.TrimSpace(r.FormValue("content"))
 	c.Title = strings.TrimSpace(r.FormValue("title"))
+	c.Tags = strings.TrimSpace(r.FormValue("tags"))
+
+	uploads, errmsg, err := readUploads(r)
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+	c.Attachments = errmsg
+
+	if r.FormValue("preview") != "" {
+		if cat, err := strconv.Atoi(r.FormValue("category")); err == nil {
+			c.Category = uint(cat)
+		}
+		if cats, err := NewStore(ctx, DB(ctx)).Categories(); err != nil {
+			tmpl.Render500(w, err)
+		} else if c.Header, err = loadHeader(NewStore(ctx, DB(ctx)), r); err != nil {
+			tmpl.Render500(w, err)
+		} else {
+			c.Categories = cats
+			c.Preview = true
+			c.Reattach = hasUploads(r)
+			tmpl.Render(w, http.StatusOK, "page_create_topic", c)
+		}
+		return
+	}
 
 	if len(c.Title) < 3 {
-		c.TitleErr = "Title must be at least 3 characters long"
+		c.TitleErr = i18n.M("Title must be at least 3 characters long")
 	}
 	if len(c.Title) > 200 {
-		c.TitleErr = "Title must not be longer than 200 characters"
+		c.TitleErr = i18n.M("Title must not be longer than 200 characters")
 	}
 	if len(c.Content) < 3 {
-		c.ContentErr = "Content must be at least 3 characters long"
+		c.ContentErr = i18n.M("Content must be at least 3 characters long")
 	}
 	if len(c.Content) > 10000 {
-		c.ContentErr = "Content must be shorter than 10000 characters"
+		c.ContentErr = i18n.M("Content must be shorter than 10000 characters")
 	}
+	tags, errmsg := parseTags(c.Tags)
+	c.TagsErr = errmsg
 	if raw := r.FormValue("category"); raw == "" {
-		c.CategoryErr = "Category is required"
+		c.CategoryErr = i18n.M("Category is required")
 	} else {
 		if cat, err := strconv.Atoi(r.FormValue("category")); err == nil {
 			c.Category = uint(cat)
 		} else {
-			c.CategoryErr = "Invalid category"
+			c.CategoryErr = i18n.M("Invalid category")
 		}
 	}
 
-	if c.TitleErr != "" || c.ContentErr != "" || c.CategoryErr != "" {
-		if cats, err := NewStore(DB(ctx)).Categories(); err != nil {
+	if c.TitleErr != nil || c.ContentErr != nil || c.CategoryErr != nil || c.TagsErr != nil || c.Attachments != nil {
+		if cats, err := NewStore(ctx, DB(ctx)).Categories(); err != nil {
+			tmpl.Render500(w, err)
+		} else if c.Header, err = loadHeader(NewStore(ctx, DB(ctx)), r); err != nil {
 			tmpl.Render500(w, err)
 		} else {
 			c.Categories = cats
@@ -98,14 +140,27 @@ func HandleCreateTopic(ctx context.Context, w http.ResponseWriter, r *http.Reque
 		return
 	}
 	defer tx.Rollback()
-	store := NewStore(tx)
+	store := NewStore(ctx, tx)
 	now := time.Now()
 	topic, err := store.CreateTopic(c.Title, uid, c.Category, now)
 	if err != nil {
 		tmpl.Render500(w, err)
 		return
 	}
-	if _, err := store.CreateMessage(topic.TopicID, uid, c.Content, now); err != nil {
+	if err := store.AddTopicTags(topic.TopicID, tags); err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+	m, err := store.CreateMessage(topic.TopicID, uid, linkAttachments(c.Content, uploads), nil, now)
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+	if err := saveAttachments(ctx, store, m, uploads); err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+	if err := notifyAbout(store, m, nil); err != nil {
 		tmpl.Render500(w, err)
 		return
 	}
@@ -113,55 +168,128 @@ func HandleCreateTopic(ctx context.Context, w http.ResponseWriter, r *http.Reque
 		tmpl.Render500(w, err)
 		return
 	}
-	turl := fmt.Sprintf("/t/%d/%s", topic.TopicID, topic.Slug())
+	store.flushInvalidated()
+	turl := fmt.Sprintf("/t/%d/%s/", topic.TopicID, topic.Slug())
 	http.Redirect(w, r, turl, http.StatusFound)
 }
 
 func HandleListTopics(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-	store := NewStore(DB(ctx))
+	store := NewStore(ctx, DB(ctx))
 
-	p := NewSimplePaginator(time.Now())
-	if sec, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil {
-		p.Current = int(sec)
-	}
+	filter := ParseTopicFilter(r.URL.Query())
+	sort := topicsSort(w, r)
 
-	if t, err := store.LastTopicUpdated(time.Unix(int64(p.Current), 0)); err != nil {
+	sizes, err := loadPageSizes(ctx, store, r)
+	if err != nil {
 		tmpl.Render500(w, err)
 		return
-	} else if checkLastModified(w, r, t) {
+	}
+
+	header, err := loadHeader(store, r)
+	if err != nil {
+		tmpl.Render500(w, err)
 		return
 	}
 
-	var categories []int
-	for _, raw := range r.URL.Query()["category"] {
-		if id, err := strconv.Atoi(raw); err == nil {
-			categories = append(categories, id)
+	p := NewKeysetPaginator(r.URL.Query(), sort, sizes.Topics)
+	if sort == defaultTopicsSort && p.IsFirst() {
+		// only the first page of the latest activity order is changing
+		// when the most recently updated topic changes
+		t, err := store.LastTopicUpdated()
+		if err != nil && err != ErrNotFound {
+			tmpl.Render500(w, err)
+			return
+		}
+		etag := pageETag(header, r, t.UnixNano(), r.URL.RawQuery, sort, sizes.Topics, sizes.Messages)
+		if checkNotModified(ctx, w, r, header, etag) {
+			return
 		}
 	}
 
-	topics, err := store.Topics(categories, time.Unix(int64(p.Current), 0), p.Limit())
+	cursor, before := p.Cursor()
+	sorted, err := store.SortedTopics(TopicOrders[sort], filter, cursor, before, p.Limit())
 	if err != nil {
 		tmpl.Render500(w, err)
 		return
 	}
-
-	// if there are less topics than the page size, then this is the last page
-	if len(topics) == PageSize {
-		p.Next = int(topics[len(topics)-1].Updated.Unix())
+	cursors := make([]*TopicCursor, len(sorted))
+	for i, t := range sorted {
+		cursors[i] = t.Cursor(sort)
+	}
+	from, to := p.SetPage(cursors)
+	topics := make([]*TopicWithUserCategory, 0, to-from)
+	for _, t := range sorted[from:to] {
+		topics = append(topics, &t.TopicWithUserCategory)
 	}
 
 	c := struct {
-		Topics     []*TopicWithUserCategory
-		Pagination *SimplePaginator
-		URLQuery   URLQueryBuilder
+		*Header    `json:"header"`
+		Topics     []*TopicWithUserCategory `json:"topics"`
+		Sort       string                   `json:"sort"`
+		Sorts      interface{}              `json:"sorts"`
+		Pagination *KeysetPaginator         `json:"pagination"`
+		URLQuery   URLQueryBuilder          `json:"-"`
+		// MessagesPageSize is required to link the last page of topics
+		MessagesPageSize int `json:"messages_page_size"`
 	}{
-		Topics:     topics,
-		Pagination: p,
-		URLQuery:   URLQueryBuilder{r},
+		Header:           header,
+		Topics:           topics,
+		Sort:             sort,
+		Sorts:            topicsSorts,
+		Pagination:       p,
+		URLQuery:         URLQueryBuilder{r},
+		MessagesPageSize: sizes.Messages,
 	}
 	tmpl.Render(w, http.StatusOK, "page_topic_list", c)
 }
 
+// defaultTopicsSort is the order of topics sorted by the last activity.
+const defaultTopicsSort = "activity"
+
+// topicsSort return the order in which topics should be listed. Order
+// explicitly chosen with the "sort" query parameter is remembered in a
+// cookie and used when the list is displayed without one.
+func topicsSort(w http.ResponseWriter, r *http.Request) string {
+	w.Header().Add("Vary", "Cookie")
+
+	if sort := r.URL.Query().Get("sort"); sort != "" {
+		if !isTopicsSort(sort) {
+			sort = defaultTopicsSort
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:    "topics_sort",
+			Value:   sort,
+			Path:    "/",
+			Expires: time.Now().Add(365 * 24 * time.Hour),
+		})
+		return sort
+	}
+	if c, err := r.Cookie("topics_sort"); err == nil {
+		if isTopicsSort(c.Value) {
+			return c.Value
+		}
+	}
+	return defaultTopicsSort
+}
+
+func isTopicsSort(name string) bool {
+	_, ok := TopicOrders[name]
+	return ok
+}
+
+// topicsSorts is the list of all topic orders, as presented to the user.
+var topicsSorts = []struct {
+	Name  string `json:"name"`
+	Label string `json:"label"`
+}{
+	{defaultTopicsSort, "Activity"},
+	{"hot", "Hot"},
+	{"new", "New"},
+	{"replies", "Most replies"},
+	{"views", "Most viewed"},
+	{"unanswered", "Unanswered"},
+}
+
 func HandleCreateMessage(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	uid, ok := CurrentUserID(r)
 	if !ok {
@@ -176,7 +304,26 @@ func HandleCreateMessage(ctx context.Context, w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	if err := r.ParseMultipartForm(2 << 20); err != nil {
+		tmpl.Render400(w, err.Error())
+		return
+	}
+
 	content := strings.TrimSpace(r.FormValue("content"))
+	var replyTo uint
+	if id, err := strconv.Atoi(r.FormValue("reply_to")); err == nil && id > 0 {
+		replyTo = uint(id)
+	}
+	if r.FormValue("preview") != "" {
+		renderTopicMessages(ctx, w, r, &replyForm{
+			Content:  content,
+			ReplyTo:  replyTo,
+			Preview:  true,
+			Reattach: hasUploads(r),
+		})
+		return
+	}
 	if len(content) < 3 {
 		tmpl.Render400(w, "Message too short")
 		return
@@ -186,6 +333,16 @@ func HandleCreateMessage(ctx context.Context, w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	uploads, errmsg, err := readUploads(r)
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+	if errmsg != nil {
+		tmpl.Render400Message(w, errmsg)
+		return
+	}
+
 	tx, err := DB(ctx).Beginx()
 	if err != nil {
 		tmpl.Render500(w, err)
@@ -193,7 +350,7 @@ func HandleCreateMessage(ctx context.Context, w http.ResponseWriter, r *http.Req
 	}
 	defer tx.Rollback()
 
-	store := NewStore(tx)
+	store := NewStore(ctx, tx)
 
 	t, err := store.TopicByID(uint(tid))
 	if err != nil {
@@ -205,7 +362,37 @@ func HandleCreateMessage(ctx context.Context, w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	m, err := store.CreateMessage(t.TopicID, uid, content, time.Now())
+	var replyToID *uint
+	if replyTo != 0 {
+		// silently ignore references to messages from other topics
+		if target, err := store.MessageByID(replyTo); err == nil && target.TopicID == t.TopicID {
+			replyToID = &target.MessageID
+		} else if err != nil && err != ErrNotFound {
+			tmpl.Render500(w, err)
+			return
+		}
+	}
+
+	m, err := store.CreateMessage(t.TopicID, uid, linkAttachments(content, uploads), replyToID, time.Now())
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+	if err := saveAttachments(ctx, store, m, uploads); err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+	if err := notifyAbout(store, m, &t.Topic); err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+
+	sizes, err := loadPageSizes(ctx, store, r)
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+	murl, err := messageURL(store, m, sizes.Messages)
 	if err != nil {
 		tmpl.Render500(w, err)
 		return
@@ -215,21 +402,86 @@ func HandleCreateMessage(ctx context.Context, w http.ResponseWriter, r *http.Req
 		tmpl.Render500(w, err)
 		return
 	}
+	store.flushInvalidated()
 
-	murl := fmt.Sprintf(
-		"/t/%d/%s?page=%d#m%d",
-		t.TopicID, t.Topic.Slug(), t.Pages(), m.MessageID)
 	http.Redirect(w, r, murl, http.StatusFound)
 }
 
+// HandleMessage redirect to topic page that displays message with given ID.
+// This is the stable permalink of every message.
+func HandleMessage(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	store := NewStore(ctx, DB(ctx))
+
+	messageID, err := strconv.Atoi(param(ctx, "messageid"))
+	if err != nil || messageID < 0 {
+		tmpl.Render404(w, "Message does not exist")
+		return
+	}
+	m, err := store.MessageByID(uint(messageID))
+	if err == ErrNotFound {
+		tmpl.Render404(w, "Message does not exist")
+		return
+	}
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+	sizes, err := loadPageSizes(ctx, store, r)
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+	murl, err := messageURL(store, &m.Message, sizes.Messages)
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+	http.Redirect(w, r, murl, http.StatusFound)
+}
+
+// messageURL return URL of the topic page that displays given message, when
+// topic is paginated using given page size.
+func messageURL(s *store, m *Message, pageSize int) (string, error) {
+	t, err := s.TopicByID(m.TopicID)
+	if err != nil {
+		return "", err
+	}
+	positions, err := s.MessagePositions([]uint{m.MessageID})
+	if err != nil {
+		return "", err
+	}
+	if len(positions) == 0 {
+		return "", ErrNotFound
+	}
+	murl := fmt.Sprintf(
+		"/t/%d/%s/?page=%d#m%d",
+		t.TopicID, t.Topic.Slug(), positions[0].Page(pageSize), m.MessageID)
+	return murl, nil
+}
+
 func HandleListTopicMessages(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	renderTopicMessages(ctx, w, r, nil)
+}
+
+// replyForm is the state of the reply form displayed below topic messages.
+type replyForm struct {
+	Content  string `json:"content"`
+	ReplyTo  uint   `json:"reply_to"`
+	Preview  bool   `json:"preview"`
+	Reattach bool   `json:"reattach"`
+}
+
+// renderTopicMessages render page with topic messages. If reply form is
+// given, last page is always displayed, with the form filled with provided
+// state.
+func renderTopicMessages(ctx context.Context, w http.ResponseWriter, r *http.Request, form *replyForm) {
 	tx, err := DB(ctx).Beginx()
 	if err != nil {
 		panic(err)
 	}
 	defer tx.Rollback()
 
-	store := NewStore(tx)
+	store := NewStore(ctx, tx)
 
 	topicID, err := strconv.Atoi(param(ctx, "topicid"))
 	if err != nil || topicID < 0 {
@@ -246,46 +498,206 @@ func HandleListTopicMessages(ctx context.Context, w http.ResponseWriter, r *http
 		return
 	}
 
-	if checkLastModified(w, r, topic.Updated) {
+	if r.Method == "GET" {
+		tpath := fmt.Sprintf("/t/%d/%s/", topic.TopicID, topic.Topic.Slug())
+		if redirectCanonical(w, r, tpath) {
+			return
+		}
+		TopicViews(ctx).Count(topic.TopicID, viewerID(r), time.Now())
+	}
+
+	q := r.URL.Query()
+	if form == nil {
+		if id, err := strconv.Atoi(q.Get("quote")); err == nil && id > 0 {
+			quoted, err := store.MessageByID(uint(id))
+			if err != nil && err != ErrNotFound {
+				tmpl.Render500(w, err)
+				return
+			}
+			if err == nil && quoted.TopicID == topic.TopicID {
+				form = &replyForm{
+					Content: quote(quoted),
+					ReplyTo: quoted.MessageID,
+				}
+			}
+		}
+	}
+	sizes, err := loadPageSizes(ctx, store, r)
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+	header, err := loadHeader(store, r)
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+
+	if form != nil {
+		q.Set("page", fmt.Sprint(topic.Pages(sizes.Messages)))
+	} else if checkNotModified(ctx, w, r, header, pageETag(header, r, topic.Updated.UnixNano(), r.URL.RawQuery, sizes.Messages)) {
 		return
+	} else {
+		form = &replyForm{}
 	}
 
-	p := NewPaginator(r.URL.Query(), int(topic.Replies+1))
-	messages, err := store.TopicMessages(topic.TopicID, p.Offset(), p.Limit())
+	total := topic.Replies + 1
+	p := NewPaginator(q, int(total), sizes.Messages)
+	messages, err := store.TopicMessages(topic.TopicID, total, p.Offset(), p.Limit())
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+
+	ids := make([]uint, 0, len(messages))
+	for _, m := range messages {
+		ids = append(ids, m.MessageID)
+	}
+	attachments, err := store.MessagesAttachments(ids)
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+	tags, err := store.TopicTags(topic.TopicID)
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+
+	var replyTargets []uint
+	for _, m := range messages {
+		if m.ReplyTo != nil {
+			replyTargets = append(replyTargets, *m.ReplyTo)
+		}
+	}
+	positions, err := store.MessagePositions(replyTargets)
 	if err != nil {
 		tmpl.Render500(w, err)
 		return
 	}
 
 	type MessageWithUserPos struct {
-		*Message
-		*User
-		CollectionPos int // position number in messages collection
+		*Message      `json:"message"`
+		*User         `json:"user"`
+		CollectionPos int                  `json:"position"` // position number in messages collection
+		Attachments   []*MessageAttachment `json:"attachments"`
+		ReplyToPos    *MessagePosition     `json:"reply_to_pos"`
+		ReplyToPage   uint                 `json:"reply_to_page"`
 	}
 
 	emsgs := make([]*MessageWithUserPos, 0, len(messages))
 	for i, m := range messages {
-		emsgs = append(emsgs, &MessageWithUserPos{
+		em := &MessageWithUserPos{
 			CollectionPos: i + (p.CurrentPage()-1)*int(p.PageSize()) + 1,
 			Message:       &m.Message,
 			User:          &m.User,
-		})
+		}
+		for _, a := range attachments {
+			if a.MessageID == m.MessageID {
+				em.Attachments = append(em.Attachments, a)
+			}
+		}
+		for _, pos := range positions {
+			if m.ReplyTo != nil && pos.MessageID == *m.ReplyTo {
+				em.ReplyToPos = pos
+				em.ReplyToPage = pos.Page(sizes.Messages)
+			}
+		}
+		emsgs = append(emsgs, em)
 	}
 
 	c := struct {
-		Topic     *TopicWithUserCategory
-		Messages  []*MessageWithUserPos
-		Paginator *Paginator
+		*Header   `json:"header"`
+		Topic     *TopicWithUserCategory `json:"topic"`
+		Tags      []string               `json:"tags"`
+		Messages  []*MessageWithUserPos  `json:"messages"`
+		Paginator *Paginator             `json:"paginator"`
+		Reply     *replyForm             `json:"reply"`
 	}{
+		Header:    header,
 		Topic:     topic,
+		Tags:      tags,
 		Messages:  emsgs,
 		Paginator: p,
+		Reply:     form,
 	}
 	tmpl.Render(w, http.StatusOK, "page_message_list", c)
 }
 
+// quote return markdown blockquote with given message content, attributed to
+// message author and linking to quoted message.
+func quote(m *MessageWithUser) string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "> [@%s wrote](#m%d):\n", m.User.Login, m.MessageID)
+	for _, line := range strings.Split(strings.TrimSpace(m.Content), "\n") {
+		b.WriteString(strings.TrimRight("> "+line, " \r"))
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// HandlePreview render markdown content submitted as "content" form value
+// exactly the same way it would be displayed as a message.
+func HandlePreview(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+	content := r.FormValue("content")
+	tmpl.Render(w, http.StatusOK, "markdown_preview", content)
+}
+
+// HandleUserByLogin redirect to details page of user with given login. This
+// is where mentions are linking to.
+func HandleUserByLogin(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	u, err := NewStore(ctx, DB(ctx)).UserByLogin(param(ctx, "login"))
+	if err != nil {
+		if err == ErrNotFound {
+			tmpl.Render404(w, "User does not exist")
+		} else {
+			tmpl.Render500(w, err)
+		}
+		return
+	}
+	uurl := fmt.Sprintf("/u/%d/%s/", u.UserID, u.Slug())
+	http.Redirect(w, r, uurl, http.StatusFound)
+}
+
 func HandleUserDetails(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-	http.Error(w, "not implemented", http.StatusNotImplemented)
+	store := NewStore(ctx, DB(ctx))
+
+	userID, err := strconv.Atoi(param(ctx, "userid"))
+	if err != nil || userID < 0 {
+		tmpl.Render404(w, "User does not exist")
+		return
+	}
+	user, err := store.UserByID(uint(userID))
+	if err == ErrNotFound {
+		tmpl.Render404(w, "User does not exist")
+		return
+	}
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+
+	upath := fmt.Sprintf("/u/%d/%s/", user.UserID, user.Slug())
+	if redirectCanonical(w, r, upath) {
+		return
+	}
+
+	header, err := loadHeader(store, r)
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+
+	c := struct {
+		*Header `json:"header"`
+		User    *User `json:"user"`
+	}{
+		Header: header,
+		User:   user,
+	}
+	tmpl.Render(w, http.StatusOK, "page_user_details", c)
 }
 
 func HandleListCategories(ctx context.Context, w http.ResponseWriter, r *http.Request) {
@@ -320,24 +732,37 @@ func (b URLQueryBuilder) Without(keys ...string) template.URL {
 	return template.URL(q.Encode())
 }
 
-var httpNoCache = os.Getenv("DEV") == "1"
+// Sort return URL query string from wrapped Request with the sort order
+// changed. Pagination is reset, because it is specific to the order.
+func (b URLQueryBuilder) Sort(name string) template.URL {
+	q := b.Request.URL.Query()
+	delete(q, "after")
+	delete(q, "before")
+	q.Set("sort", name)
+	return template.URL(q.Encode())
+}
+
+// Cursor return URL query string from wrapped Request with the pagination
+// cursor replaced. Key must be either "after" or "before". Empty key reset
+// pagination to the first page.
+func (b URLQueryBuilder) Cursor(key, cursor string) template.URL {
+	q := b.Request.URL.Query()
+	delete(q, "after")
+	delete(q, "before")
+	if key != "" {
+		q.Set(key, cursor)
+	}
+	return template.URL(q.Encode())
+}
 
-// checkLastModified inspect HTTP header and if document did not changed,
-// StatusNotModified response is returned. Function return true if document was
-// not changed, false if response must be rendered.
-func checkLastModified(w http.ResponseWriter, r *http.Request, modtime time.Time) bool {
-	if httpNoCache {
+// redirectCanonical respond with permanent redirect to given canonical path if
+// requested path is different. URL query is preserved. Function return true
+// if redirect response was written.
+func redirectCanonical(w http.ResponseWriter, r *http.Request, path string) bool {
+	if r.URL.Path == path {
 		return false
 	}
-	// https://golang.org/src/net/http/fs.go#L273
-	ms, err := time.Parse(http.TimeFormat, r.Header.Get("If-Modified-Since"))
-	if err == nil && modtime.Before(ms.Add(1*time.Second)) {
-		h := w.Header()
-		delete(h, "Content-Type")
-		delete(h, "Content-Length")
-		w.WriteHeader(http.StatusNotModified)
-		return true
-	}
-	w.Header().Set("Last-Modified", modtime.UTC().Format(http.TimeFormat))
-	return false
+	u := url.URL{Path: path, RawQuery: r.URL.RawQuery}
+	http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+	return true
 }
@@ -0,0 +1,92 @@
+package forum
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/husio/bb/tmpl"
+	"golang.org/x/net/context"
+)
+
+// WithHTTPCache return context with HTTP caching of pages enabled or
+// disabled. Caching should be disabled in development mode, so that
+// template changes are visible immediately.
+func WithHTTPCache(ctx context.Context, enabled bool) context.Context {
+	return context.WithValue(ctx, "http:cache", enabled)
+}
+
+// HTTPCache return true if HTTP caching of pages is enabled. It is enabled
+// unless disabled explicitly.
+func HTTPCache(ctx context.Context) bool {
+	if enabled, ok := ctx.Value("http:cache").(bool); ok {
+		return enabled
+	}
+	return true
+}
+
+// serverVersion is part of every entity tag, because a different version
+// of the server may render the same content differently.
+var serverVersion = strconv.FormatInt(time.Now().UnixNano(), 36)
+
+// pageETag return strong entity tag of the page displaying content of given
+// version. Version must describe everything the page depends on, apart from
+// the user, the header, the negotiated representation and the current time,
+// which are always included.
+//
+// Pages display relative times, like "3 minutes ago", so the tag changes
+// every minute, which is the finest unit used by those.
+func pageETag(h *Header, r *http.Request, version ...interface{}) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\x00%d\x00%d\x00%d\x00%s\x00%s\x00%s\x00%d\x00%s\x00%d\x00",
+		serverVersion, h.UserID, h.UnreadNotifications, h.UnreadConversations,
+		h.Theme, h.Locale, h.Location, tmpl.MarkdownVersion, r.Header.Get("Accept"),
+		time.Now().Truncate(time.Minute).Unix())
+	for _, v := range version {
+		fmt.Fprintf(hash, "%v\x00", v)
+	}
+	return `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+}
+
+// checkNotModified set caching headers of the page with given entity tag. If
+// the client already has the page, StatusNotModified response is written
+// and true returned. Pages of logged in users must not be stored by shared
+// caches.
+func checkNotModified(ctx context.Context, w http.ResponseWriter, r *http.Request, h *Header, etag string) bool {
+	if !HTTPCache(ctx) {
+		return false
+	}
+
+	header := w.Header()
+	header.Add("Vary", "Cookie")
+	if h.UserID != 0 {
+		header.Set("Cache-Control", "private, no-cache")
+	} else {
+		header.Set("Cache-Control", "no-cache")
+	}
+	header.Set("ETag", etag)
+
+	if !etagMatch(r.Header.Get("If-None-Match"), etag) {
+		return false
+	}
+	delete(header, "Content-Type")
+	delete(header, "Content-Length")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatch return true if If-None-Match header value matches given entity
+// tag. Comparison is weak, as required for If-None-Match.
+func etagMatch(ifNoneMatch, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
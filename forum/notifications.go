@@ -0,0 +1,206 @@
+package forum
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/husio/bb/i18n"
+	"github.com/husio/bb/tmpl"
+	"golang.org/x/net/context"
+)
+
+// Header is the context of page_navbar template, rendered on top of every
+// forum page.
+type Header struct {
+	UserID              uint `json:"user_id"`
+	UnreadNotifications int  `json:"unread_notifications"`
+	UnreadConversations int  `json:"unread_conversations"`
+	// Theme chosen by the user, empty for the default one
+	Theme string `json:"theme"`
+	// Locale negotiated with the client
+	Locale string `json:"locale"`
+	// Location is the timezone chosen by the user, server timezone by default
+	Location *time.Location `json:"-"`
+}
+
+// TemplateTheme implements tmpl.Themed, so that every page embedding the
+// header is rendered with the theme chosen by the user.
+func (h *Header) TemplateTheme() string {
+	return h.Theme
+}
+
+// TemplateLocale implements tmpl.Localized, so that every page embedding the
+// header is rendered in the language of the user.
+func (h *Header) TemplateLocale() string {
+	return h.Locale
+}
+
+func loadHeader(s *store, r *http.Request) (*Header, error) {
+	accept := r.Header.Get("Accept-Language")
+	uid, ok := CurrentUserID(r)
+	if !ok {
+		return &Header{Locale: i18n.Negotiate(accept, ""), Location: time.Local}, nil
+	}
+	h := Header{UserID: uid, Location: time.Local}
+	prefs, err := s.UserPreferences(uid)
+	if err != nil {
+		return nil, err
+	}
+	h.Theme = prefs.Theme
+	h.Locale = i18n.Negotiate(accept, prefs.Locale)
+	if prefs.Timezone != "" {
+		// timezone was valid when saved, but the timezone database may
+		// have changed since then
+		if loc, err := time.LoadLocation(prefs.Timezone); err == nil {
+			h.Location = loc
+		}
+	}
+	if h.UnreadNotifications, err = s.UnreadNotificationsCount(uid); err != nil {
+		return nil, err
+	}
+	if h.UnreadConversations, err = s.UnreadConversationsCount(uid); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// notifyAbout create notifications for all users interested in given, just
+// created message: mentioned users, authors of quoted messages and, if message
+// is a reply, author of the topic. Message author is never notified and every
+// user gets at most one notification for a single message.
+//
+// Topic must be nil if the message is the first message of a new topic.
+func notifyAbout(s *store, m *Message, replyTo *Topic) error {
+	notified := map[uint]bool{m.AuthorID: true}
+	notify := func(user uint, kind string) error {
+		if notified[user] {
+			return nil
+		}
+		notified[user] = true
+		return s.CreateNotification(user, m.AuthorID, m.MessageID, kind, m.Created)
+	}
+
+	if logins := tmpl.Mentions(m.Content); len(logins) != 0 {
+		users, err := s.UsersByLogin(logins)
+		if err != nil {
+			return err
+		}
+		for _, u := range users {
+			if err := notify(uint(u.UserID), NotificationMention); err != nil {
+				return err
+			}
+		}
+	}
+
+	if ids := quotedMessages(m.Content); len(ids) != 0 {
+		quoted, err := s.MessagesByID(ids)
+		if err != nil {
+			return err
+		}
+		for _, q := range quoted {
+			if err := notify(q.AuthorID, NotificationQuote); err != nil {
+				return err
+			}
+		}
+	}
+
+	if replyTo != nil {
+		if err := notify(replyTo.AuthorID, NotificationReply); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// quotedMessages return IDs of all messages referenced by "#m<id>" anchors
+// from within a blockquote.
+func quotedMessages(content string) []uint {
+	var ids []uint
+	for _, line := range strings.Split(content, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), ">") {
+			continue
+		}
+		for _, m := range quoterx.FindAllStringSubmatch(line, -1) {
+			if id, err := strconv.Atoi(m[1]); err == nil {
+				ids = append(ids, uint(id))
+			}
+		}
+	}
+	return ids
+}
+
+var quoterx = regexp.MustCompile(`#m(\d+)\b`)
+
+func HandleListNotifications(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	uid, ok := CurrentUserID(r)
+	if !ok {
+		// TODO - redirect to authentication page
+		tmpl.Render500(w, errors.New("not implemented"))
+		return
+	}
+
+	tx, err := DB(ctx).Beginx()
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	store := NewStore(ctx, tx)
+
+	total, err := store.NotificationsCount(uid)
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+	sizes, err := loadPageSizes(ctx, store, r)
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+	p := NewPaginator(r.URL.Query(), total, sizes.Topics)
+	notifications, err := store.Notifications(uid, p.Offset(), p.Limit())
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+
+	var unseen []uint
+	for _, n := range notifications {
+		if !n.Seen {
+			unseen = append(unseen, n.NotificationID)
+		}
+	}
+	if len(unseen) != 0 {
+		if err := store.MarkNotificationsSeen(uid, unseen); err != nil {
+			tmpl.Render500(w, err)
+			return
+		}
+	}
+
+	header, err := loadHeader(store, r)
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+
+	c := struct {
+		*Header       `json:"header"`
+		Notifications []*NotificationWithContext `json:"notifications"`
+		Paginator     *Paginator                 `json:"paginator"`
+	}{
+		Header:        header,
+		Notifications: notifications,
+		Paginator:     p,
+	}
+	tmpl.Render(w, http.StatusOK, "page_notification_list", c)
+}
@@ -1,30 +1,47 @@
 package forum
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"net/url"
 	"strconv"
-	"time"
+	"strings"
 )
 
-const PageSize = 25
+const (
+	// DefaultPageSize is the page size used when not configured otherwise.
+	DefaultPageSize = 25
+
+	MinPageSize = 5
+	MaxPageSize = 100
+)
+
+// pageCount return the number of pages required to display given amount of
+// entities.
+func pageCount(entities uint, pageSize int) uint {
+	return uint(math.Ceil(float64(entities) / float64(pageSize)))
+}
 
 type Paginator struct {
 	page          int
+	pageSize      int
 	entitiesCount int
 	pagesCount    int
 }
 
-func NewPaginator(q url.Values, entities int) *Paginator {
+func NewPaginator(q url.Values, entities int, pageSize int) *Paginator {
 	page, _ := strconv.Atoi(q.Get("page"))
 	if page < 1 {
 		page = 1
 	}
 	return &Paginator{
 		page:          page,
+		pageSize:      pageSize,
 		entitiesCount: entities,
-		pagesCount:    int(math.Ceil(float64(entities) / float64(PageSize))),
+		pagesCount:    int(pageCount(uint(entities), pageSize)),
 	}
 }
 
@@ -36,6 +53,21 @@ func (p *Paginator) PageCount() int {
 	return p.pagesCount
 }
 
+// MarshalJSON implements json.Marshaler.
+func (p *Paginator) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Page     int `json:"page"`
+		PageSize int `json:"page_size"`
+		Pages    int `json:"pages"`
+		Total    int `json:"total"`
+	}{
+		Page:     p.page,
+		PageSize: p.pageSize,
+		Pages:    p.pagesCount,
+		Total:    p.entitiesCount,
+	})
+}
+
 type PagPage struct {
 	Number   int
 	Label    string
@@ -87,7 +119,7 @@ func (p *Paginator) LastPage() int {
 }
 
 func (p *Paginator) HasNext() bool {
-	return p.entitiesCount > (p.page * PageSize)
+	return p.entitiesCount > (p.page * p.pageSize)
 }
 
 func (p *Paginator) NextPage() int {
@@ -103,52 +135,196 @@ func (p *Paginator) PrevPage() int {
 }
 
 func (p *Paginator) Offset() uint {
-	return uint((p.page - 1) * PageSize)
+	return uint((p.page - 1) * p.pageSize)
 }
 
 func (p *Paginator) Limit() uint {
-	return uint(PageSize)
+	return uint(p.pageSize)
 }
 
 func (p *Paginator) PageSize() uint {
-	return PageSize
+	return uint(p.pageSize)
 }
 
-type SimplePaginator struct {
-	Current int
-	Next    int
-	now     int
+// TopicCursor is the position in the list of topics ordered by a sort key.
+// Topics with the same sort key are ordered by their ID, so that every
+// position is unique.
+type TopicCursor struct {
+	// Sort is the name of the order the cursor belongs to.
+	Sort string
+	// Key is the value of the sort key, formatted by the database.
+	Key     string
+	TopicID uint
 }
 
-func NewSimplePaginator(now time.Time) *SimplePaginator {
-	unix := int(now.Unix())
-	return &SimplePaginator{
-		now:     unix,
-		Current: unix,
-		Next:    0,
+// String return opaque representation of the cursor, that can be used as
+// URL query value.
+func (c *TopicCursor) String() string {
+	raw := fmt.Sprintf("%s|%s|%d", c.Sort, c.Key, c.TopicID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// ParseTopicCursor return cursor from its string representation. Returned
+// cursor belongs to a known order and its key is a valid value of the
+// order's sort key type.
+func ParseTopicCursor(s string) (*TopicCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	fields := strings.SplitN(string(raw), "|", 2)
+	if len(fields) != 2 {
+		return nil, errInvalidCursor
+	}
+	order, ok := TopicOrders[fields[0]]
+	if !ok {
+		return nil, errInvalidCursor
 	}
+	i := strings.LastIndex(fields[1], "|")
+	if i < 0 {
+		return nil, errInvalidCursor
+	}
+	id, err := strconv.ParseUint(fields[1][i+1:], 10, 32)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	key := fields[1][:i]
+	if !order.validKey(key) {
+		return nil, errInvalidCursor
+	}
+	return &TopicCursor{Sort: fields[0], Key: key, TopicID: uint(id)}, nil
 }
 
-func (p SimplePaginator) CurrentPage() int {
-	return p.Current
+// KeysetPaginator paginate topics using cursors pointing at the topic
+// right before or right after the displayed page. Pages can be requested in
+// both directions: "after" cursor display the page following given topic,
+// "before" cursor display the page preceding it.
+type KeysetPaginator struct {
+	After  *TopicCursor
+	Before *TopicCursor
+
+	// Prev and Next are set once the page is loaded and are pointing at the
+	// first and the last topic of the page, if there are pages before or
+	// after the current one
+	Prev *TopicCursor
+	Next *TopicCursor
+
+	pageSize int
 }
 
-func (p SimplePaginator) IsFirst() bool {
-	return p.Current == p.now
+// NewKeysetPaginator return paginator for the page of topics in given order,
+// described by the "after" or "before" query value. Invalid cursor, or cursor
+// of a different order, is pointing to the first page.
+func NewKeysetPaginator(q url.Values, sort string, pageSize int) *KeysetPaginator {
+	p := KeysetPaginator{pageSize: pageSize}
+	if raw := q.Get("before"); raw != "" {
+		p.Before = parseSortCursor(raw, sort)
+	} else if raw := q.Get("after"); raw != "" {
+		p.After = parseSortCursor(raw, sort)
+	}
+	return &p
 }
 
-func (p SimplePaginator) HasNext() bool {
-	return p.Next != 0
+// parseSortCursor return cursor of given order or nil.
+func parseSortCursor(raw, sort string) *TopicCursor {
+	c, err := ParseTopicCursor(raw)
+	if err != nil || c.Sort != sort {
+		return nil
+	}
+	return c
+}
+
+// Cursor return the cursor the page should be loaded from and true if the
+// page precedes it.
+func (p *KeysetPaginator) Cursor() (*TopicCursor, bool) {
+	if p.Before != nil {
+		return p.Before, true
+	}
+	return p.After, false
+}
+
+// SetPage update paginator with the cursors of the loaded items, in the
+// order they are displayed. Items must be loaded with the paginator's limit,
+// one more than the page size, to tell if there are more pages in the
+// loading direction. Returned are the bounds of the items that belong to
+// the page.
+func (p *KeysetPaginator) SetPage(cursors []*TopicCursor) (from, to int) {
+	more := len(cursors) > p.pageSize
+	if more {
+		if p.Before != nil {
+			// page was loaded backward, so the extra item is the first one
+			cursors = cursors[1:]
+			from = 1
+		} else {
+			cursors = cursors[:p.pageSize]
+		}
+	}
+	if len(cursors) == 0 {
+		return 0, 0
+	}
+	if p.Before != nil {
+		if more {
+			p.Prev = cursors[0]
+		}
+		p.Next = cursors[len(cursors)-1]
+	} else {
+		if more {
+			p.Next = cursors[len(cursors)-1]
+		}
+		if p.After != nil {
+			p.Prev = cursors[0]
+		}
+	}
+	return from, from + len(cursors)
+}
+
+func (p *KeysetPaginator) IsFirst() bool {
+	return p.After == nil && p.Before == nil
+}
+
+func (p *KeysetPaginator) HasPrev() bool {
+	return p.Prev != nil
+}
+
+func (p *KeysetPaginator) PrevPage() string {
+	if p.Prev == nil {
+		return ""
+	}
+	return p.Prev.String()
+}
+
+func (p *KeysetPaginator) HasNext() bool {
+	return p.Next != nil
+}
+
+func (p *KeysetPaginator) NextPage() string {
+	if p.Next == nil {
+		return ""
+	}
+	return p.Next.String()
 }
 
-func (p SimplePaginator) NextPage() int {
-	return p.Next
+// MarshalJSON implements json.Marshaler. Previous and next page cursors are
+// the "before" and "after" query values of the neighbouring pages.
+func (p *KeysetPaginator) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		PageSize int    `json:"page_size"`
+		Prev     string `json:"prev,omitempty"`
+		Next     string `json:"next,omitempty"`
+	}{
+		PageSize: p.pageSize,
+		Prev:     p.PrevPage(),
+		Next:     p.NextPage(),
+	})
 }
 
-func (p SimplePaginator) Limit() uint {
-	return uint(PageSize)
+// Limit return the number of items that should be loaded for the page.
+func (p *KeysetPaginator) Limit() uint {
+	return uint(p.pageSize) + 1
 }
 
-func (p *SimplePaginator) PageSize() uint {
-	return PageSize
+func (p *KeysetPaginator) PageSize() uint {
+	return uint(p.pageSize)
 }
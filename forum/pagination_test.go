@@ -0,0 +1,66 @@
+package forum
+
+import (
+	"encoding/base64"
+	"net/url"
+	"testing"
+)
+
+func TestParseTopicCursor(t *testing.T) {
+	cases := map[string]struct {
+		raw   string
+		valid bool
+	}{
+		"timestamp":                  {"activity|2016-03-01 12:34:56.123456+01|42", true},
+		"timestamp without fraction": {"new|2016-03-01 12:34:56+01|42", true},
+		"timestamp with minutes":     {"unanswered|2016-03-01 12:34:56.5+05:30|42", true},
+		"integer":                    {"replies|17|42", true},
+		"numeric":                    {"hot|31234.12345678|42", true},
+		"invalid timestamp":          {"activity|yesterday|42", false},
+		"timestamp as integer":       {"replies|2016-03-01 12:34:56+01|42", false},
+		"integer overflow":           {"views|99999999999|42", false},
+		"numeric expression":         {"hot|1); DROP TABLE topics; --|42", false},
+		"unknown sort":               {"oldest|17|42", false},
+		"no sort":                    {"17|42", false},
+		"invalid id":                 {"replies|17|x", false},
+		"no id":                      {"replies|17", false},
+	}
+	for name, tc := range cases {
+		s := base64.RawURLEncoding.EncodeToString([]byte(tc.raw))
+		c, err := ParseTopicCursor(s)
+		if tc.valid && err != nil {
+			t.Errorf("%s: cannot parse: %s", name, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("%s: invalid cursor accepted: %+v", name, c)
+		}
+	}
+
+	if _, err := ParseTopicCursor("%%%"); err == nil {
+		t.Error("invalid encoding accepted")
+	}
+}
+
+func TestTopicCursorString(t *testing.T) {
+	c := &TopicCursor{Sort: "activity", Key: "2016-03-01 12:34:56.123456+01", TopicID: 42}
+	got, err := ParseTopicCursor(c.String())
+	if err != nil {
+		t.Fatalf("cannot parse: %s", err)
+	}
+	if *got != *c {
+		t.Fatalf("want %+v, got %+v", c, got)
+	}
+}
+
+func TestKeysetPaginatorSortMismatch(t *testing.T) {
+	c := &TopicCursor{Sort: "activity", Key: "2016-03-01 12:34:56+01", TopicID: 42}
+	q := url.Values{"after": {c.String()}}
+
+	if p := NewKeysetPaginator(q, "activity", 10); p.IsFirst() {
+		t.Error("cursor of the same order ignored")
+	}
+	// cursor from a different tab, using a different order
+	if p := NewKeysetPaginator(q, "replies", 10); !p.IsFirst() {
+		t.Error("cursor of a different order used")
+	}
+}
@@ -3,10 +3,11 @@ package forum
 import (
 	"database/sql"
 	"errors"
-	"fmt"
-	"strings"
+	"regexp"
+	"strconv"
 	"time"
 
+	"github.com/husio/bb/tmpl"
 	"github.com/jmoiron/sqlx"
 	"golang.org/x/net/context"
 
@@ -25,12 +26,18 @@ func DB(ctx context.Context) *sqlx.DB {
 	return ctx.Value("db:connection").(*sqlx.DB)
 }
 
-func NewStore(c dbconn) *store {
-	return &store{db: c}
+// NewStore return store using given connection. Results of the most
+// frequent queries are cached if the context provides a cache.
+func NewStore(ctx context.Context, c dbconn) *store {
+	return &store{db: c, cache: StoreCache(ctx)}
 }
 
 type store struct {
-	db dbconn
+	db    dbconn
+	cache Cache
+
+	// invalidated is true if the store invalidated any cached values
+	invalidated bool
 }
 
 type dbconn interface {
@@ -45,52 +52,155 @@ func (s *store) UserByID(userID uint) (*User, error) {
 	return &u, transformErr(err)
 }
 
-func (s *store) LastTopicUpdated(updatedGte time.Time) (time.Time, error) {
+func (s *store) UserByLogin(login string) (*User, error) {
+	var u User
+	err := s.db.Get(&u, `SELECT * FROM users WHERE login = $1`, login)
+	return &u, transformErr(err)
+}
+
+func (s *store) UsersByLogin(logins []string) ([]*User, error) {
+	var users []*User
+	err := s.db.Select(&users, `
+		SELECT * FROM users WHERE login = ANY($1)
+	`, pq.Array(logins))
+	return users, transformErr(err)
+}
+
+// LastTopicUpdated return the time of the most recent activity in any of
+// the topics.
+func (s *store) LastTopicUpdated() (time.Time, error) {
 	var t time.Time
-	err := s.db.Get(&t, `
-		SELECT updated FROM topics WHERE updated < $1
-		ORDER BY updated DESC
-		LIMIT 1
-	`, updatedGte)
-	return t, transformErr(err)
+	err := s.cached(s.topicsCacheKey("updated"), &t, func() error {
+		return transformErr(s.db.Get(&t, `
+			SELECT updated FROM topics
+			ORDER BY updated DESC
+			LIMIT 1
+		`))
+	})
+	return t, err
+}
+
+// TopicOrder defines how topics are sorted. Topics are always sorted in
+// descending order of the sort expression and then by their ID.
+type TopicOrder struct {
+	// Expr is the SQL expression topics are sorted by.
+	Expr string
+	// Type is the SQL type of the expression, used to convert cursor key
+	// back from text. It must be one of those accepted by validKey.
+	Type string
+	// Where is an optional SQL condition that topics must match.
+	Where string
+}
+
+// hotTopicExpr is the time decayed popularity score. Every 12.5 hours of
+// activity age are worth as much as ten times more replies and views. Score
+// is rounded, so that its text representation is exact.
+const hotTopicExpr = `ROUND((
+	LOG(GREATEST(t.replies * 10 + t.views, 1)::float8)
+	+ EXTRACT(EPOCH FROM t.updated)::float8 / 45000
+)::numeric, 8)`
+
+var TopicOrders = map[string]*TopicOrder{
+	"activity":   {Expr: "t.updated", Type: "timestamptz"},
+	"hot":        {Expr: hotTopicExpr, Type: "numeric"},
+	"new":        {Expr: "t.created", Type: "timestamptz"},
+	"replies":    {Expr: "t.replies", Type: "integer"},
+	"views":      {Expr: "t.views", Type: "integer"},
+	"unanswered": {Expr: "t.created", Type: "timestamptz", Where: "t.replies = 0"},
+}
+
+// validKey return true if given text is a value of the sort key type, as
+// formatted by the database.
+func (o *TopicOrder) validKey(key string) bool {
+	switch o.Type {
+	case "timestamptz":
+		for _, layout := range pgTimestampLayouts {
+			if _, err := time.Parse(layout, key); err == nil {
+				return true
+			}
+		}
+		return false
+	case "integer":
+		_, err := strconv.ParseInt(key, 10, 32)
+		return err == nil
+	case "numeric":
+		return numericRx.MatchString(key)
+	default:
+		return false
+	}
+}
+
+// pgTimestampLayouts are the text representations of timestamptz in ISO
+// date style. Time zone offset is displayed with minutes and seconds only
+// when those are not zero.
+var pgTimestampLayouts = []string{
+	"2006-01-02 15:04:05.999999-07",
+	"2006-01-02 15:04:05.999999-07:00",
+	"2006-01-02 15:04:05.999999-07:00:00",
 }
 
-func (s *store) Topics(
-	categories []int,
-	updatedGte time.Time,
+var numericRx = regexp.MustCompile(`^-?[0-9]{1,20}(\.[0-9]{1,20})?$`)
+
+// SortedTopic is a topic together with the value of the key it is sorted
+// by.
+type SortedTopic struct {
+	TopicWithUserCategory
+	SortKey string `db:"sort_key"`
+}
+
+// Cursor return position of the topic in the order with given name.
+func (t *SortedTopic) Cursor(sort string) *TopicCursor {
+	return &TopicCursor{Sort: sort, Key: t.SortKey, TopicID: t.TopicID}
+}
+
+// SortedTopics return topics matching the filter in given order, starting
+// right after the position pointed by the cursor or, if before is true,
+// ending right before it. Filter and cursor are optional.
+func (s *store) SortedTopics(
+	order *TopicOrder,
+	filter *TopicFilter,
+	cursor *TopicCursor,
+	before bool,
 	limit uint,
-) ([]*TopicWithUserCategory, error) {
-	var (
-		topics []*TopicWithUserCategory
-		query  string
-	)
-
-	if len(categories) == 0 {
-		query = `
-			SELECT t.*, u.*, c.*
-			FROM topics t
-				INNER JOIN users u ON t.author_id = u.user_id
-				INNER JOIN categories c ON t.category_id = c.category_id
-			WHERE t.updated < $1
-			ORDER BY t.updated DESC LIMIT $2
-		`
-	} else {
-		var ids []string
-		for _, id := range categories {
-			ids = append(ids, fmt.Sprint(id))
+) ([]*SortedTopic, error) {
+	var topics []*SortedTopic
+	key := s.topicsCacheKey(order.Expr, order.Where, filter, cursor, before, limit)
+	err := s.cached(key, &topics, func() error {
+		var q topicQuery
+		q.filter(filter)
+		query := q.sorted(order, cursor, before, limit)
+		return transformErr(s.db.Select(&topics, query, q.args...))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if before {
+		for i, j := 0, len(topics)-1; i < j; i, j = i+1, j-1 {
+			topics[i], topics[j] = topics[j], topics[i]
 		}
-		query = fmt.Sprintf(`
-			SELECT t.*, u.*, c.*
-			FROM topics t
-				INNER JOIN users u ON t.author_id = u.user_id
-				INNER JOIN categories c ON t.category_id = c.category_id
-			WHERE t.updated < $1
-				AND t.category_id IN (%s)
-			ORDER BY t.updated DESC LIMIT $2
-		`, strings.Join(ids, ", "))
 	}
-	err := s.db.Select(&topics, query, updatedGte, limit)
-	return topics, transformErr(err)
+	return topics, nil
+}
+
+// IncrementTopicViews increase views counter of every topic by given value.
+func (s *store) IncrementTopicViews(views map[uint]uint) error {
+	if len(views) == 0 {
+		return nil
+	}
+	ids := make([]int64, 0, len(views))
+	counts := make([]int64, 0, len(views))
+	for id, count := range views {
+		ids = append(ids, int64(id))
+		counts = append(counts, int64(count))
+	}
+	_, err := s.db.Exec(`
+		UPDATE topics SET views = views + v.count
+		FROM (
+			SELECT UNNEST($1::integer[]) AS topic_id, UNNEST($2::integer[]) AS count
+		) v
+		WHERE topics.topic_id = v.topic_id
+	`, pq.Array(ids), pq.Array(counts))
+	return transformErr(err)
 }
 
 func (s *store) CreateTopic(title string, author, category uint, now time.Time) (*Topic, error) {
@@ -100,9 +210,39 @@ func (s *store) CreateTopic(title string, author, category uint, now time.Time)
 		VALUES ($1, $2, $3, $4, $4, 0)
 		RETURNING *
 	`, title, author, category, now)
+	if err == nil {
+		s.invalidateTopics()
+	}
 	return &t, transformErr(err)
 }
 
+// AddTopicTags tag topic with given tags.
+func (s *store) AddTopicTags(topicID uint, tags []string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO topic_tags (topic_id, tag)
+		SELECT $1, UNNEST($2::text[])
+		ON CONFLICT DO NOTHING
+	`, topicID, pq.Array(tags))
+	if err == nil {
+		s.invalidateTopics()
+	}
+	return transformErr(err)
+}
+
+// TopicTags return tags of given topic, in alphabetical order.
+func (s *store) TopicTags(topicID uint) ([]string, error) {
+	var tags []string
+	err := s.db.Select(&tags, `
+		SELECT tag FROM topic_tags
+		WHERE topic_id = $1
+		ORDER BY tag
+	`, topicID)
+	return tags, transformErr(err)
+}
+
 func (s *store) TopicByID(topicID uint) (*TopicWithUserCategory, error) {
 	var t TopicWithUserCategory
 	err := s.db.Get(&t, `
@@ -116,32 +256,408 @@ func (s *store) TopicByID(topicID uint) (*TopicWithUserCategory, error) {
 	return &t, transformErr(err)
 }
 
-func (s *store) TopicMessages(topicID uint, offset, limit uint) ([]*MessageWithUser, error) {
+// TopicMessages return page of topic messages, skipping given amount of
+// messages. Total is the number of all topic messages.
+//
+// Instead of skipping rows of the full result, the first message of the page
+// is found using only the (topic_id, created, message_id) index and the page
+// is loaded starting from its position. Pages closer to the end of the topic
+// are looked up from the end, so that the deepest page is as fast as the
+// first one.
+func (s *store) TopicMessages(topicID uint, total, offset, limit uint) ([]*MessageWithUser, error) {
+	if offset >= total {
+		return nil, nil
+	}
+	if offset <= total/2 {
+		var messages []*MessageWithUser
+		err := s.db.Select(&messages, `
+			WITH first AS (
+				SELECT created, message_id FROM messages
+				WHERE topic_id = $1
+				ORDER BY created ASC, message_id ASC OFFSET $2 LIMIT 1
+			)
+			SELECT m.*, u.*
+			FROM messages m
+				INNER JOIN users u ON m.author_id = u.user_id,
+				first f
+			WHERE m.topic_id = $1
+				AND (m.created, m.message_id) >= (f.created, f.message_id)
+			ORDER BY m.created ASC, m.message_id ASC LIMIT $3
+		`, topicID, offset, limit)
+		return messages, transformErr(err)
+	}
+
+	if offset+limit > total {
+		limit = total - offset
+	}
 	var messages []*MessageWithUser
 	err := s.db.Select(&messages, `
+		WITH last AS (
+			SELECT created, message_id FROM messages
+			WHERE topic_id = $1
+			ORDER BY created DESC, message_id DESC OFFSET $2 LIMIT 1
+		)
 		SELECT m.*, u.*
 		FROM messages m
-			INNER JOIN users u ON m.author_id = u.user_id
+			INNER JOIN users u ON m.author_id = u.user_id,
+			last l
 		WHERE m.topic_id = $1
+			AND (m.created, m.message_id) <= (l.created, l.message_id)
+		ORDER BY m.created DESC, m.message_id DESC LIMIT $3
+	`, topicID, total-offset-limit, limit)
+	if err != nil {
+		return nil, transformErr(err)
+	}
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+// CreateMessage create message with given content. Content is rendered to
+// HTML and stored together with the source. Reply to message ID is optional.
+func (s *store) CreateMessage(topic, author uint, content string, replyTo *uint, now time.Time) (*Message, error) {
+	var m Message
+	err := s.db.Get(&m, `
+		INSERT INTO messages (topic_id, author_id, content, content_html, content_version, reply_to_message_id, created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING *
+	`, topic, author, content, tmpl.RenderMarkdown(content), tmpl.MarkdownVersion, replyTo, now)
+	if err == nil {
+		s.invalidateTopics()
+	}
+	return &m, transformErr(err)
+}
+
+func (s *store) MessageByID(message uint) (*MessageWithUser, error) {
+	var m MessageWithUser
+	err := s.db.Get(&m, `
+		SELECT m.*, u.*
+		FROM messages m
+			INNER JOIN users u ON m.author_id = u.user_id
+		WHERE m.message_id = $1
+		LIMIT 1
+	`, message)
+	return &m, transformErr(err)
+}
+
+// MessagePositions return position of every given message within its topic,
+// counting from 1. Position is using the same ordering as TopicMessages.
+func (s *store) MessagePositions(messages []uint) ([]*MessagePosition, error) {
+	if len(messages) == 0 {
+		return nil, nil
+	}
+	args := make([]int64, 0, len(messages))
+	for _, id := range messages {
+		args = append(args, int64(id))
+	}
+	var positions []*MessagePosition
+	err := s.db.Select(&positions, `
+		SELECT
+			m.message_id,
+			(
+				SELECT COUNT(*) FROM messages
+				WHERE topic_id = m.topic_id
+					AND (created, message_id) <= (m.created, m.message_id)
+			) AS position
+		FROM messages m
+		WHERE m.message_id = ANY($1)
+	`, pq.Array(args))
+	return positions, transformErr(err)
+}
+
+// OutdatedMessages return messages rendered by versions of markdown renderer
+// older than given one. Messages rendered by newer versions are left alone,
+// so that servers of different versions running together do not render the
+// same messages over and over.
+func (s *store) OutdatedMessages(version uint, limit uint) ([]*Message, error) {
+	var messages []*Message
+	err := s.db.Select(&messages, `
+		SELECT * FROM messages
+		WHERE content_version < $1
+		ORDER BY message_id DESC
+		LIMIT $2
+	`, version, limit)
+	return messages, transformErr(err)
+}
+
+func (s *store) UpdateMessageHTML(message uint, html string, version uint) error {
+	_, err := s.db.Exec(`
+		UPDATE messages SET content_html = $2, content_version = $3
+		WHERE message_id = $1
+	`, message, html, version)
+	return transformErr(err)
+}
+
+func (s *store) MessagesByID(ids []uint) ([]*Message, error) {
+	args := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, int64(id))
+	}
+	var messages []*Message
+	err := s.db.Select(&messages, `
+		SELECT * FROM messages WHERE message_id = ANY($1)
+	`, pq.Array(args))
+	return messages, transformErr(err)
+}
+
+// CreateNotification create notification for given user about a message.
+// Every user is notified about single message only once - if such
+// notification already exists, nothing is created.
+func (s *store) CreateNotification(user, actor, message uint, kind string, now time.Time) error {
+	_, err := s.db.Exec(`
+		INSERT INTO notifications (user_id, actor_id, message_id, kind, created)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, message_id) DO NOTHING
+	`, user, actor, message, kind, now)
+	return transformErr(err)
+}
+
+func (s *store) Notifications(user uint, offset, limit uint) ([]*NotificationWithContext, error) {
+	var notifications []*NotificationWithContext
+	err := s.db.Select(&notifications, `
+		SELECT
+			n.*,
+			u.login AS actor_login,
+			t.topic_id,
+			t.title AS topic_title
+		FROM notifications n
+			INNER JOIN users u ON n.actor_id = u.user_id
+			INNER JOIN messages m ON n.message_id = m.message_id
+			INNER JOIN topics t ON m.topic_id = t.topic_id
+		WHERE n.user_id = $1
+		ORDER BY n.created DESC OFFSET $2 LIMIT $3
+	`, user, offset, limit)
+	return notifications, transformErr(err)
+}
+
+func (s *store) NotificationsCount(user uint) (int, error) {
+	var count int
+	err := s.db.Get(&count, `
+		SELECT COUNT(*) FROM notifications WHERE user_id = $1
+	`, user)
+	return count, transformErr(err)
+}
+
+func (s *store) UnreadNotificationsCount(user uint) (int, error) {
+	var count int
+	err := s.db.Get(&count, `
+		SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND NOT seen
+	`, user)
+	return count, transformErr(err)
+}
+
+func (s *store) MarkNotificationsSeen(user uint, ids []uint) error {
+	args := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, int64(id))
+	}
+	_, err := s.db.Exec(`
+		UPDATE notifications SET seen = true
+		WHERE user_id = $1 AND notification_id = ANY($2)
+	`, user, pq.Array(args))
+	return transformErr(err)
+}
+
+func (s *store) AttachmentByHash(hash string) (*Attachment, error) {
+	var a Attachment
+	err := s.db.Get(&a, `SELECT * FROM attachments WHERE hash = $1`, hash)
+	return &a, transformErr(err)
+}
+
+// CreateAttachment create attachment with given content hash. If attachment
+// with the same hash already exists, it is returned instead.
+func (s *store) CreateAttachment(hash, contentType string, size uint, thumbnail bool, now time.Time) (*Attachment, error) {
+	var a Attachment
+	err := s.db.Get(&a, `
+		INSERT INTO attachments (hash, content_type, size, thumbnail, created)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (hash) DO UPDATE SET hash = EXCLUDED.hash
+		RETURNING *
+	`, hash, contentType, size, thumbnail, now)
+	return &a, transformErr(err)
+}
+
+func (s *store) AddMessageAttachment(message, attachment uint, name string) error {
+	_, err := s.db.Exec(`
+		INSERT INTO message_attachments (message_id, attachment_id, name)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (message_id, attachment_id) DO NOTHING
+	`, message, attachment, name)
+	return transformErr(err)
+}
+
+func (s *store) MessagesAttachments(messages []uint) ([]*MessageAttachment, error) {
+	args := make([]int64, 0, len(messages))
+	for _, id := range messages {
+		args = append(args, int64(id))
+	}
+	var attachments []*MessageAttachment
+	err := s.db.Select(&attachments, `
+		SELECT a.*, ma.message_id, ma.name
+		FROM message_attachments ma
+			INNER JOIN attachments a ON ma.attachment_id = a.attachment_id
+		WHERE ma.message_id = ANY($1)
+		ORDER BY ma.name
+	`, pq.Array(args))
+	return attachments, transformErr(err)
+}
+
+func (s *store) CreateConversation(subject string, now time.Time) (*Conversation, error) {
+	var c Conversation
+	err := s.db.Get(&c, `
+		INSERT INTO conversations (subject, created, updated, messages_count)
+		VALUES ($1, $2, $2, 0)
+		RETURNING *
+	`, subject, now)
+	return &c, transformErr(err)
+}
+
+// AddConversationParticipant grant user access to conversation. All messages
+// created before lastRead are considered read by that user.
+func (s *store) AddConversationParticipant(conversation, user uint, lastRead time.Time) error {
+	_, err := s.db.Exec(`
+		INSERT INTO conversation_participants (conversation_id, user_id, last_read)
+		VALUES ($1, $2, $3)
+	`, conversation, user, lastRead)
+	return transformErr(err)
+}
+
+// ConversationForParticipant return conversation with given ID, but only if
+// given user is one of its participants. ErrNotFound is returned otherwise.
+func (s *store) ConversationForParticipant(conversation, user uint) (*Conversation, error) {
+	var c Conversation
+	err := s.db.Get(&c, `
+		SELECT c.*
+		FROM conversations c
+			INNER JOIN conversation_participants p ON c.conversation_id = p.conversation_id
+		WHERE c.conversation_id = $1 AND p.user_id = $2
+		LIMIT 1
+	`, conversation, user)
+	return &c, transformErr(err)
+}
+
+func (s *store) ConversationParticipants(conversation uint) ([]*User, error) {
+	var users []*User
+	err := s.db.Select(&users, `
+		SELECT u.*
+		FROM users u
+			INNER JOIN conversation_participants p ON u.user_id = p.user_id
+		WHERE p.conversation_id = $1
+		ORDER BY u.login
+	`, conversation)
+	return users, transformErr(err)
+}
+
+func (s *store) Conversations(user uint, offset, limit uint) ([]*ConversationWithUnread, error) {
+	var convs []*ConversationWithUnread
+	err := s.db.Select(&convs, `
+		SELECT
+			c.*,
+			(
+				SELECT COUNT(*) FROM private_messages m
+				WHERE m.conversation_id = c.conversation_id
+					AND m.created > p.last_read
+					AND m.author_id != p.user_id
+			) AS unread
+		FROM conversations c
+			INNER JOIN conversation_participants p ON c.conversation_id = p.conversation_id
+		WHERE p.user_id = $1
+		ORDER BY c.updated DESC OFFSET $2 LIMIT $3
+	`, user, offset, limit)
+	return convs, transformErr(err)
+}
+
+func (s *store) ConversationsCount(user uint) (int, error) {
+	var count int
+	err := s.db.Get(&count, `
+		SELECT COUNT(*) FROM conversation_participants WHERE user_id = $1
+	`, user)
+	return count, transformErr(err)
+}
+
+// UnreadConversationsCount return number of conversations with messages
+// written by other participants that given user did not read yet.
+func (s *store) UnreadConversationsCount(user uint) (int, error) {
+	var count int
+	err := s.db.Get(&count, `
+		SELECT COUNT(*)
+		FROM conversation_participants p
+		WHERE p.user_id = $1
+			AND EXISTS (
+				SELECT 1 FROM private_messages m
+				WHERE m.conversation_id = p.conversation_id
+					AND m.created > p.last_read
+					AND m.author_id != p.user_id
+			)
+	`, user)
+	return count, transformErr(err)
+}
+
+func (s *store) MarkConversationRead(conversation, user uint, lastRead time.Time) error {
+	_, err := s.db.Exec(`
+		UPDATE conversation_participants
+		SET last_read = GREATEST(last_read, $3)
+		WHERE conversation_id = $1 AND user_id = $2
+	`, conversation, user, lastRead)
+	return transformErr(err)
+}
+
+func (s *store) ConversationMessages(conversation uint, offset, limit uint) ([]*PrivateMessageWithUser, error) {
+	var messages []*PrivateMessageWithUser
+	err := s.db.Select(&messages, `
+		SELECT m.*, u.*
+		FROM private_messages m
+			INNER JOIN users u ON m.author_id = u.user_id
+		WHERE m.conversation_id = $1
 		ORDER BY m.created ASC OFFSET $2 LIMIT $3
-	`, topicID, offset, limit)
+	`, conversation, offset, limit)
 	return messages, transformErr(err)
 }
 
-func (s *store) CreateMessage(topic, author uint, content string, now time.Time) (*Message, error) {
-	var m Message
+func (s *store) CreatePrivateMessage(conversation, author uint, content string, now time.Time) (*PrivateMessage, error) {
+	var m PrivateMessage
 	err := s.db.Get(&m, `
-		INSERT INTO messages (topic_id, author_id, content, created)
+		INSERT INTO private_messages (conversation_id, author_id, content, created)
 		VALUES ($1, $2, $3, $4)
 		RETURNING *
-	`, topic, author, content, now)
+	`, conversation, author, content, now)
 	return &m, transformErr(err)
 }
 
 func (s *store) Categories() ([]*Category, error) {
 	var cats []*Category
-	err := s.db.Select(&cats, `SELECT * FROM categories LIMIT 1000`)
-	return cats, transformErr(err)
+	err := s.cached(categoriesCacheKey, &cats, func() error {
+		return transformErr(s.db.Select(&cats, `SELECT * FROM categories LIMIT 1000`))
+	})
+	return cats, err
+}
+
+// UserPreferences return preferences of given user. Preferences of user that
+// never changed them are all defaults.
+func (s *store) UserPreferences(userID uint) (*Preferences, error) {
+	var p Preferences
+	err := s.db.Get(&p, `
+		SELECT * FROM user_preferences WHERE user_id = $1 LIMIT 1
+	`, userID)
+	if err == sql.ErrNoRows {
+		return &Preferences{UserID: userID}, nil
+	}
+	return &p, transformErr(err)
+}
+
+func (s *store) SetUserPreferences(p *Preferences) error {
+	_, err := s.db.Exec(`
+		INSERT INTO user_preferences (user_id, topics_page_size, messages_page_size, theme, locale, timezone)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id) DO UPDATE SET
+			topics_page_size = EXCLUDED.topics_page_size,
+			messages_page_size = EXCLUDED.messages_page_size,
+			theme = EXCLUDED.theme,
+			locale = EXCLUDED.locale,
+			timezone = EXCLUDED.timezone
+	`, p.UserID, p.TopicsPageSize, p.MessagesPageSize, p.Theme, p.Locale, p.Timezone)
+	return transformErr(err)
 }
 
 var (
@@ -0,0 +1,134 @@
+package forum
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/net/context"
+)
+
+// testDB return connection to the database given by BB_TEST_DB environment
+// variable, for example
+//
+//	BB_TEST_DB="user=bb password=bb dbname=bb_test sslmode=disable"
+//
+// The schema is created if missing. Test is skipped if the variable is not
+// set.
+func testDB(tb testing.TB) *sqlx.DB {
+	cred := os.Getenv("BB_TEST_DB")
+	if cred == "" {
+		tb.Skip("BB_TEST_DB not set")
+	}
+	db, err := sqlx.Connect("postgres", cred)
+	if err != nil {
+		tb.Fatalf("cannot connect to database: %s", err)
+	}
+	schema, err := ioutil.ReadFile("../schema/forum.sql")
+	if err != nil {
+		tb.Fatalf("cannot read schema: %s", err)
+	}
+	if _, err := db.Exec(string(schema)); err != nil {
+		tb.Fatalf("cannot create schema: %s", err)
+	}
+	return db
+}
+
+// seedTopic create a topic with given number of messages, created one second
+// apart.
+func seedTopic(tb testing.TB, tx *sqlx.Tx, messages int) *Topic {
+	start := time.Now().Add(-time.Duration(messages) * time.Second)
+
+	var userID, categoryID uint
+	login := fmt.Sprintf("seed-%d", time.Now().UnixNano())
+	if err := tx.Get(&userID, `INSERT INTO users (login) VALUES ($1) RETURNING user_id`, login); err != nil {
+		tb.Fatalf("cannot create user: %s", err)
+	}
+	err := tx.Get(&categoryID, `
+		INSERT INTO categories (name, description) VALUES ('Seed', '')
+		RETURNING category_id
+	`)
+	if err != nil {
+		tb.Fatalf("cannot create category: %s", err)
+	}
+	var topicID uint
+	err = tx.Get(&topicID, `
+		INSERT INTO topics (title, author_id, category_id, created, updated)
+		VALUES ($1, $2, $3, $4, $4)
+		RETURNING topic_id
+	`, fmt.Sprintf("Topic with %d messages", messages), userID, categoryID, start)
+	if err != nil {
+		tb.Fatalf("cannot create topic: %s", err)
+	}
+	_, err = tx.Exec(`
+		INSERT INTO messages (topic_id, author_id, content, created)
+		SELECT $1, $2, 'Message number ' || n, $3::timestamptz + n * interval '1 second'
+		FROM generate_series(0, $4 - 1) n
+	`, topicID, userID, start, messages)
+	if err != nil {
+		tb.Fatalf("cannot create messages: %s", err)
+	}
+
+	topic, err := NewStore(context.Background(), tx).TopicByID(topicID)
+	if err != nil {
+		tb.Fatalf("cannot get topic: %s", err)
+	}
+	return &topic.Topic
+}
+
+// BenchmarkTopicMessages compare loading pages of a long topic by keyset,
+// as done by the store, with plain OFFSET query.
+func BenchmarkTopicMessages(b *testing.B) {
+	const messages = 20000
+
+	db := testDB(b)
+	defer db.Close()
+	tx, err := db.Beginx()
+	if err != nil {
+		b.Fatalf("cannot start transaction: %s", err)
+	}
+	// all seeded data is removed once done
+	defer tx.Rollback()
+
+	topic := seedTopic(b, tx, messages)
+	store := NewStore(context.Background(), tx)
+	total := topic.Replies + 1
+	pages := topic.Pages(DefaultPageSize)
+
+	for _, page := range []struct {
+		name string
+		page uint
+	}{
+		{"first", 1},
+		{"middle", pages / 2},
+		{"last", pages},
+	} {
+		offset := (page.page - 1) * DefaultPageSize
+
+		b.Run("keyset/"+page.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := store.TopicMessages(topic.TopicID, total, offset, DefaultPageSize); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run("offset/"+page.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				var messages []*MessageWithUser
+				err := tx.Select(&messages, `
+					SELECT m.*, u.*
+					FROM messages m
+						INNER JOIN users u ON m.author_id = u.user_id
+					WHERE m.topic_id = $1
+					ORDER BY m.created ASC, m.message_id ASC OFFSET $2 LIMIT $3
+				`, topic.TopicID, offset, DefaultPageSize)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package forum
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/husio/bb/i18n"
+	"github.com/husio/bb/tmpl"
+	"golang.org/x/net/context"
+)
+
+// WithPageSize return context with the deployment default page size set.
+func WithPageSize(ctx context.Context, size int) context.Context {
+	return context.WithValue(ctx, "page:size", size)
+}
+
+// PageSize return the deployment default page size.
+func PageSize(ctx context.Context) int {
+	if size, ok := ctx.Value("page:size").(int); ok {
+		return size
+	}
+	return DefaultPageSize
+}
+
+// pageSizes is the number of entities displayed on a single page. Topics
+// size is used by all lists, messages size by topic and conversation pages.
+type pageSizes struct {
+	Topics   int
+	Messages int
+}
+
+// loadPageSizes return page sizes chosen by the current user, with the
+// deployment default used for those not set.
+func loadPageSizes(ctx context.Context, s *store, r *http.Request) (*pageSizes, error) {
+	size := PageSize(ctx)
+	sizes := pageSizes{Topics: size, Messages: size}
+	uid, ok := CurrentUserID(r)
+	if !ok {
+		return &sizes, nil
+	}
+	prefs, err := s.UserPreferences(uid)
+	if err != nil {
+		return nil, err
+	}
+	if prefs.TopicsPageSize != 0 {
+		sizes.Topics = prefs.TopicsPageSize
+	}
+	if prefs.MessagesPageSize != 0 {
+		sizes.Messages = prefs.MessagesPageSize
+	}
+	return &sizes, nil
+}
+
+func HandlePreferences(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	uid, ok := CurrentUserID(r)
+	if !ok {
+		// TODO - redirect to authentication page
+		tmpl.Render500(w, errors.New("not implemented"))
+		return
+	}
+
+	store := NewStore(ctx, DB(ctx))
+
+	prefs, err := store.UserPreferences(uid)
+	if err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+
+	var c struct {
+		*Header         `json:"header"`
+		Preferences     *Preferences   `json:"preferences"`
+		DefaultPageSize int            `json:"default_page_size"`
+		MinPageSize     int            `json:"min_page_size"`
+		MaxPageSize     int            `json:"max_page_size"`
+		PageSizeErr     *i18n.Message  `json:"page_size_err"`
+		Themes          []string       `json:"themes"`
+		ThemeErr        *i18n.Message  `json:"theme_err"`
+		Locales         []localeOption `json:"locales"`
+		LocaleErr       *i18n.Message  `json:"locale_err"`
+		TimezoneErr     *i18n.Message  `json:"timezone_err"`
+		Saved           bool           `json:"saved"`
+	}
+	c.Preferences = prefs
+	c.DefaultPageSize = PageSize(ctx)
+	c.MinPageSize = MinPageSize
+	c.MaxPageSize = MaxPageSize
+	c.Themes = tmpl.Themes()
+	for _, code := range i18n.Locales() {
+		c.Locales = append(c.Locales, localeOption{Code: code, Name: i18n.LocaleName(code)})
+	}
+
+	status := http.StatusOK
+	if r.Method == "POST" {
+		var errmsg *i18n.Message
+		if prefs.TopicsPageSize, errmsg = parsePageSize(r.FormValue("topics_page_size")); errmsg != nil {
+			c.PageSizeErr = errmsg
+		}
+		if prefs.MessagesPageSize, errmsg = parsePageSize(r.FormValue("messages_page_size")); errmsg != nil {
+			c.PageSizeErr = errmsg
+		}
+
+		prefs.Theme = r.FormValue("theme")
+		if prefs.Theme != "" && !isTheme(prefs.Theme) {
+			c.ThemeErr = i18n.M("Unknown theme")
+		}
+
+		prefs.Locale = r.FormValue("locale")
+		if prefs.Locale != "" && !i18n.IsLocale(prefs.Locale) {
+			c.LocaleErr = i18n.M("Unknown language")
+		}
+
+		prefs.Timezone = strings.TrimSpace(r.FormValue("timezone"))
+		if prefs.Timezone != "" {
+			if _, err := time.LoadLocation(prefs.Timezone); err != nil {
+				c.TimezoneErr = i18n.M("Unknown timezone")
+			}
+		}
+
+		if c.PageSizeErr != nil || c.ThemeErr != nil || c.LocaleErr != nil || c.TimezoneErr != nil {
+			status = http.StatusBadRequest
+		} else if err := store.SetUserPreferences(prefs); err != nil {
+			tmpl.Render500(w, err)
+			return
+		} else {
+			c.Saved = true
+		}
+	}
+
+	if c.Header, err = loadHeader(store, r); err != nil {
+		tmpl.Render500(w, err)
+		return
+	}
+	tmpl.Render(w, status, "page_preferences", c)
+}
+
+type localeOption struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+func isTheme(name string) bool {
+	for _, t := range tmpl.Themes() {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePageSize return page size submitted with the preferences form. Empty
+// value means the default. If the value is not valid, description of the
+// problem is returned.
+func parsePageSize(raw string) (int, *i18n.Message) {
+	if raw == "" {
+		return 0, nil
+	}
+	size, err := strconv.Atoi(raw)
+	if err != nil || size < MinPageSize || size > MaxPageSize {
+		return 0, i18n.M("Page size must be a number between %d and %d", MinPageSize, MaxPageSize)
+	}
+	return size, nil
+}
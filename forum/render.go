@@ -0,0 +1,38 @@
+package forum
+
+import (
+	"log"
+	"time"
+
+	"github.com/husio/bb/tmpl"
+	"golang.org/x/net/context"
+)
+
+// RerenderMessages render again content of all messages that were rendered by
+// a different version of markdown renderer. Messages are processed in small
+// batches, so that the database is not overloaded. Function returns when all
+// messages are up to date.
+func RerenderMessages(ctx context.Context) {
+	const batchSize = 100
+
+	store := NewStore(ctx, DB(ctx))
+	for {
+		messages, err := store.OutdatedMessages(tmpl.MarkdownVersion, batchSize)
+		if err != nil {
+			log.Printf("cannot fetch outdated messages: %s", err)
+			return
+		}
+		if len(messages) == 0 {
+			return
+		}
+		for _, m := range messages {
+			html := tmpl.RenderMarkdown(m.Content)
+			if err := store.UpdateMessageHTML(m.MessageID, html, tmpl.MarkdownVersion); err != nil {
+				log.Printf("cannot update message %d: %s", m.MessageID, err)
+				return
+			}
+		}
+		log.Printf("rendered %d outdated messages", len(messages))
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,129 @@
+package forum
+
+import (
+	"bytes"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const maxSlugLen = 140
+
+// slugify return URL friendly version of given text. Letters of Latin, Greek
+// and Cyrillic scripts are transliterated to ASCII. Letters of other scripts
+// are preserved and are percent-encoded when used in URL. Slug is never
+// longer than maxSlugLen bytes and is truncated on word boundary if possible.
+func slugify(s string) string {
+	var b bytes.Buffer
+	sep := false   // separator must be written before next word
+	ascii := false // last written character is ASCII
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsMark(r) {
+			// combining marks are dropped together with the diacritics of
+			// transliterated letters, but they are meaningful in scripts
+			// that are not transliterated
+			if !ascii && b.Len() > 0 && !sep {
+				b.WriteRune(r)
+			}
+			continue
+		}
+
+		tr, ok := transliterate(r)
+		if !ok && !unicode.IsLetter(r) && !unicode.IsNumber(r) {
+			sep = b.Len() > 0
+			continue
+		}
+		if tr == "" && ok {
+			// letter that has no representation, like Cyrillic soft sign
+			continue
+		}
+		if sep {
+			b.WriteByte('-')
+			sep = false
+		}
+		if ok {
+			b.WriteString(tr)
+			ascii = true
+		} else {
+			b.WriteRune(r)
+			ascii = false
+		}
+	}
+	return truncateSlug(b.String(), maxSlugLen)
+}
+
+// transliterate return ASCII representation of given lower case letter or
+// number. False is returned if such representation is not known.
+func transliterate(r rune) (string, bool) {
+	if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+		return string(r), true
+	}
+	if tr, ok := translit[r]; ok {
+		return tr, true
+	}
+	// letters with diacritics are decomposed into the base letter followed
+	// by combining marks
+	base, _ := utf8.DecodeRuneInString(norm.NFD.String(string(r)))
+	if base == r {
+		return "", false
+	}
+	if base >= 'a' && base <= 'z' {
+		return string(base), true
+	}
+	if tr, ok := translit[base]; ok {
+		return tr, true
+	}
+	return "", false
+}
+
+// truncateSlug return slug cut to at most max bytes. Slug is cut at the word
+// boundary, unless the first word alone is too long.
+func truncateSlug(slug string, max int) string {
+	if len(slug) <= max {
+		return slug
+	}
+	if i := strings.LastIndex(slug[:max+1], "-"); i > 0 {
+		return slug[:i]
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(slug[cut]) {
+		cut--
+	}
+	return slug[:cut]
+}
+
+// slugOr return slug of given text or fallback if the slug would be empty.
+// Slugs are used as URL path segments and an empty one would produce URLs
+// like "/t/1//".
+func slugOr(s, fallback string) string {
+	if slug := slugify(s); slug != "" {
+		return slug
+	}
+	return fallback
+}
+
+// translit is the transliteration table for lower case letters that cannot be
+// converted to ASCII by removing diacritics.
+var translit = map[rune]string{
+	// Latin
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'ł': "l", 'đ': "d", 'ð': "d",
+	'þ': "th", 'ı': "i", 'ħ': "h", 'ŧ': "t", 'ŀ': "l", 'ŋ': "ng", 'ĸ': "k",
+
+	// Cyrillic
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "yo",
+	'ж': "zh", 'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+	'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch",
+	'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+	'є': "ye", 'і': "i", 'ї': "yi", 'ґ': "g", 'ў': "u",
+	'ђ': "dj", 'ј': "j", 'љ': "lj", 'њ': "nj", 'ћ': "c", 'џ': "dz",
+	'ѓ': "gj", 'ќ': "kj", 'ѕ': "dz",
+
+	// Greek
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i",
+	'θ': "th", 'ι': "i", 'κ': "k", 'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x",
+	'ο': "o", 'π': "p", 'ρ': "r", 'σ': "s", 'ς': "s", 'τ': "t", 'υ': "y",
+	'φ': "f", 'χ': "ch", 'ψ': "ps", 'ω': "o",
+}
@@ -0,0 +1,83 @@
+package forum
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSlugify(t *testing.T) {
+	cases := map[string]struct {
+		text string
+		want string
+	}{
+		"ascii":            {"Hello World", "hello-world"},
+		"polish":           {"Zażółć gęślą jaźń", "zazolc-gesla-jazn"},
+		"polish upper":     {"ŁÓDŹ Świętokrzyska", "lodz-swietokrzyska"},
+		"german":           {"Größe über Maß", "grosse-uber-mass"},
+		"scandinavian":     {"Ærø Øresund", "aero-oresund"},
+		"russian":          {"Привет, мир!", "privet-mir"},
+		"ukrainian":        {"Львів і Одеса", "lviv-i-odesa"},
+		"greek":            {"Καλημέρα κόσμε", "kalimera-kosme"},
+		"japanese":         {"日本語のタイトル", "日本語のタイトル"},
+		"chinese":          {"你好 世界", "你好-世界"},
+		"arabic":           {"مرحبا بالعالم", "مرحبا-بالعالم"},
+		"hindi marks":      {"नमस्ते दुनिया", "नमस्ते-दुनिया"},
+		"mixed scripts":    {"Go и 日本", "go-i-日本"},
+		"punctuation":      {"  --Hello,,,   World!!!--  ", "hello-world"},
+		"symbols":          {"C++ & Go: 100% (fast)", "c-go-100-fast"},
+		"numbers":          {"Version 1.2.3", "version-1-2-3"},
+		"soft sign":        {"Объявление", "obyavlenie"},
+		"only punctuation": {"?!... --- ***", ""},
+		"empty":            {"", ""},
+		"only emoji":       {"🎉🎉", ""},
+	}
+	for name, tc := range cases {
+		if got := slugify(tc.text); got != tc.want {
+			t.Errorf("%s: want %q, got %q", name, tc.want, got)
+		}
+	}
+}
+
+func TestSlugifyTruncate(t *testing.T) {
+	cases := map[string]struct {
+		text string
+		want string
+	}{
+		"word boundary": {
+			text: strings.Repeat("abcdefghi ", 20),
+			want: strings.TrimSuffix(strings.Repeat("abcdefghi-", 14), "-"),
+		},
+		"single long word": {
+			text: strings.Repeat("a", 200),
+			want: strings.Repeat("a", maxSlugLen),
+		},
+		"multibyte single word": {
+			// every character is encoded with three bytes, so the last
+			// one that fits ends at byte 138
+			text: strings.Repeat("日", 100),
+			want: strings.Repeat("日", maxSlugLen/3),
+		},
+	}
+	for name, tc := range cases {
+		got := slugify(tc.text)
+		if got != tc.want {
+			t.Errorf("%s: want %q, got %q", name, tc.want, got)
+		}
+		if len(got) > maxSlugLen {
+			t.Errorf("%s: slug is %d bytes long", name, len(got))
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("%s: slug is not valid UTF-8", name)
+		}
+	}
+}
+
+func TestSlugOr(t *testing.T) {
+	if got := slugOr("???", "topic"); got != "topic" {
+		t.Errorf("want fallback, got %q", got)
+	}
+	if got := slugOr("Hello", "topic"); got != "hello" {
+		t.Errorf("want slug, got %q", got)
+	}
+}
@@ -0,0 +1,45 @@
+package forum
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/husio/bb/i18n"
+)
+
+const (
+	maxTopicTags = 5
+	maxTagLen    = 30
+)
+
+// parseTags return unique tags listed in given text, separated by commas or
+// spaces. Tags are lower case and can contain only letters, digits, hyphens
+// and underscores. The leading "#" is optional.
+func parseTags(s string) ([]string, *i18n.Message) {
+	var tags []string
+	seen := make(map[string]bool)
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	for _, tag := range fields {
+		tag = strings.ToLower(strings.TrimPrefix(tag, "#"))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > maxTagLen {
+			return nil, i18n.M("Tag %q is longer than %d characters", tag, maxTagLen)
+		}
+		for _, r := range tag {
+			if !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '-' && r != '_' {
+				return nil, i18n.M("Tag %q can contain only letters, digits, hyphens and underscores", tag)
+			}
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	if len(tags) > maxTopicTags {
+		return nil, i18n.M("Not more than %d tags can be given", maxTopicTags)
+	}
+	return tags, nil
+}
@@ -0,0 +1,38 @@
+package forum
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTags(t *testing.T) {
+	cases := map[string]struct {
+		text  string
+		want  []string
+		valid bool
+	}{
+		"empty":         {"", nil, true},
+		"commas":        {"go,sql, postgres", []string{"go", "sql", "postgres"}, true},
+		"spaces":        {"  go   sql\tpostgres ", []string{"go", "sql", "postgres"}, true},
+		"hash":          {"#go, #SQL", []string{"go", "sql"}, true},
+		"duplicates":    {"go, Go, #go", []string{"go"}, true},
+		"unicode":       {"żółw, ёж, c_sharp, utf-8", []string{"żółw", "ёж", "c_sharp", "utf-8"}, true},
+		"only commas":   {", ,,", nil, true},
+		"punctuation":   {"c++", nil, false},
+		"too long":      {strings.Repeat("a", maxTagLen+1), nil, false},
+		"longest":       {strings.Repeat("ą", maxTagLen), []string{strings.Repeat("ą", maxTagLen)}, true},
+		"too many":      {"a b c d e f", nil, false},
+		"many repeated": {"a b c d e a b", []string{"a", "b", "c", "d", "e"}, true},
+	}
+	for name, tc := range cases {
+		got, errmsg := parseTags(tc.text)
+		if tc.valid != (errmsg == nil) {
+			t.Errorf("%s: unexpected error: %v", name, errmsg)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: want %q, got %q", name, tc.want, got)
+		}
+	}
+}
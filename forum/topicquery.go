@@ -0,0 +1,144 @@
+package forum
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// TopicFilter describe which topics should be listed. Zero value of any of
+// the fields means that topics are not filtered by it.
+type TopicFilter struct {
+	Categories         []int
+	ExcludedCategories []int
+	AuthorID           uint
+	// CreatedSince and CreatedUntil limit topics to those created within
+	// the [since, until) range.
+	CreatedSince time.Time
+	CreatedUntil time.Time
+	// Tags limit topics to those tagged with any of given tags.
+	Tags []string
+}
+
+// ParseTopicFilter return filter described by the URL query:
+//
+//	category=1&category=2   topics from any of given categories
+//	exclude=3               topics not from given category
+//	author=4                topics created by user with given ID
+//	since=2016-01-02        topics created since given day
+//	until=2016-02-03        topics created before given day
+//	tag=go&tag=sql          topics tagged with any of given tags
+//
+// Invalid values are ignored.
+func ParseTopicFilter(q url.Values) *TopicFilter {
+	var f TopicFilter
+	f.Categories = parseIDs(q["category"])
+	f.ExcludedCategories = parseIDs(q["exclude"])
+	if id, err := strconv.ParseUint(q.Get("author"), 10, 32); err == nil {
+		f.AuthorID = uint(id)
+	}
+	if t, err := time.Parse("2006-01-02", q.Get("since")); err == nil {
+		f.CreatedSince = t
+	}
+	if t, err := time.Parse("2006-01-02", q.Get("until")); err == nil {
+		f.CreatedUntil = t
+	}
+	for _, tag := range q["tag"] {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			f.Tags = append(f.Tags, tag)
+		}
+	}
+	return &f
+}
+
+func parseIDs(raw []string) []int {
+	var ids []int
+	for _, s := range raw {
+		if id, err := strconv.Atoi(s); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// topicQuery build SQL query selecting topics together with their author and
+// category. All values are passed as query arguments.
+type topicQuery struct {
+	conds []string
+	args  []interface{}
+}
+
+// arg add query argument and return its placeholder.
+func (q *topicQuery) arg(value interface{}) string {
+	q.args = append(q.args, value)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+// where add condition that all selected topics must match.
+func (q *topicQuery) where(cond string) {
+	q.conds = append(q.conds, cond)
+}
+
+// filter add conditions of given topic filter.
+func (q *topicQuery) filter(f *TopicFilter) {
+	if f == nil {
+		return
+	}
+	if len(f.Categories) != 0 {
+		q.where("t.category_id = ANY(" + q.arg(pq.Array(f.Categories)) + ")")
+	}
+	if len(f.ExcludedCategories) != 0 {
+		q.where("t.category_id <> ALL(" + q.arg(pq.Array(f.ExcludedCategories)) + ")")
+	}
+	if f.AuthorID != 0 {
+		q.where("t.author_id = " + q.arg(f.AuthorID))
+	}
+	if !f.CreatedSince.IsZero() {
+		q.where("t.created >= " + q.arg(f.CreatedSince))
+	}
+	if !f.CreatedUntil.IsZero() {
+		q.where("t.created < " + q.arg(f.CreatedUntil))
+	}
+	if len(f.Tags) != 0 {
+		q.where(`EXISTS (
+			SELECT 1 FROM topic_tags tt
+			WHERE tt.topic_id = t.topic_id AND tt.tag = ANY(` + q.arg(pq.Array(f.Tags)) + `)
+		)`)
+	}
+}
+
+// sorted return query selecting topics in given order, starting right after
+// the cursor or, if before is true, ending right before it. Selected are
+// topics together with their sort key.
+func (q *topicQuery) sorted(order *TopicOrder, cursor *TopicCursor, before bool, limit uint) string {
+	if order.Where != "" {
+		q.where(order.Where)
+	}
+	cmp, dir := "<", "DESC"
+	if before {
+		cmp, dir = ">", "ASC"
+	}
+	if cursor != nil {
+		q.where(fmt.Sprintf("(%s, t.topic_id) %s (%s::%s, %s)",
+			order.Expr, cmp, q.arg(cursor.Key), order.Type, q.arg(cursor.TopicID)))
+	}
+	return fmt.Sprintf(`
+		SELECT t.*, u.*, c.*, (%s)::text AS sort_key
+		FROM topics t
+			INNER JOIN users u ON t.author_id = u.user_id
+			INNER JOIN categories c ON t.category_id = c.category_id
+		%s
+		ORDER BY %s %s, t.topic_id %s LIMIT %s
+	`, order.Expr, q.whereClause(), order.Expr, dir, dir, q.arg(limit))
+}
+
+func (q *topicQuery) whereClause() string {
+	if len(q.conds) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(q.conds, "\n\t\t\tAND ")
+}
@@ -0,0 +1,129 @@
+package forum
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// maxViewers limits the number of remembered viewers. When exceeded, viewers
+// are forgotten before the end of de-duplication window.
+const maxViewers = 100000
+
+// ViewCounter counts topic views. Views are de-duplicated, so that every
+// viewer is counted only once within the time window. Counts are buffered
+// in memory and written to the database in batches, so that displaying a
+// topic is not causing a database write.
+type ViewCounter struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	seen    map[topicViewer]time.Time
+	pending map[uint]uint
+}
+
+type topicViewer struct {
+	topic  uint
+	viewer string
+}
+
+func NewViewCounter(window time.Duration) *ViewCounter {
+	return &ViewCounter{
+		window:  window,
+		seen:    make(map[topicViewer]time.Time),
+		pending: make(map[uint]uint),
+	}
+}
+
+func WithTopicViews(ctx context.Context, vc *ViewCounter) context.Context {
+	return context.WithValue(ctx, "topic:views", vc)
+}
+
+func TopicViews(ctx context.Context) *ViewCounter {
+	return ctx.Value("topic:views").(*ViewCounter)
+}
+
+// Count register topic view by given viewer. View is ignored if the same
+// viewer was already counted within the time window.
+func (vc *ViewCounter) Count(topic uint, viewer string, now time.Time) {
+	key := topicViewer{topic: topic, viewer: viewer}
+
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	if last, ok := vc.seen[key]; ok && now.Sub(last) < vc.window {
+		return
+	}
+	if len(vc.seen) >= maxViewers {
+		vc.forget(now)
+	}
+	vc.seen[key] = now
+	vc.pending[topic]++
+}
+
+// forget remove viewers that are outside of the time window. If that is not
+// enough to make space for new viewers, all viewers are removed.
+func (vc *ViewCounter) forget(now time.Time) {
+	for key, last := range vc.seen {
+		if now.Sub(last) >= vc.window {
+			delete(vc.seen, key)
+		}
+	}
+	if len(vc.seen) >= maxViewers {
+		vc.seen = make(map[topicViewer]time.Time)
+	}
+}
+
+// Flush write all buffered counts to the database. On failure, counts are
+// kept and retried with the next flush.
+func (vc *ViewCounter) Flush(s *store) error {
+	vc.mu.Lock()
+	pending := vc.pending
+	vc.pending = make(map[uint]uint)
+	vc.mu.Unlock()
+
+	if err := s.IncrementTopicViews(pending); err != nil {
+		vc.mu.Lock()
+		for topic, count := range pending {
+			vc.pending[topic] += count
+		}
+		vc.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Run flush buffered counts in given intervals. This function never returns.
+func (vc *ViewCounter) Run(ctx context.Context, interval time.Duration) {
+	store := NewStore(ctx, DB(ctx))
+	for {
+		time.Sleep(interval)
+
+		if err := vc.Flush(store); err != nil {
+			log.Printf("cannot write topic views: %s", err)
+		}
+
+		vc.mu.Lock()
+		vc.forget(time.Now())
+		vc.mu.Unlock()
+	}
+}
+
+// viewerID return identifier of the client, used to de-duplicate views.
+// Authenticated users are identified by their ID, anonymous clients by their
+// IP address.
+func viewerID(r *http.Request) string {
+	if uid, ok := CurrentUserID(r); ok {
+		return fmt.Sprintf("u:%d", uid)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
@@ -0,0 +1,288 @@
+// Package i18n provides translation of user interface texts and locale
+// aware formatting.
+//
+// Texts are translated using message catalogs, one JSON file per locale,
+// mapping the English text to its translation. Texts that depend on a number
+// are translated into a list of plural forms, ordered as defined by the
+// plural rule of the locale, for example
+//
+//	{
+//		"New topic": "Nowy temat",
+//		"%d replies": ["%d odpowiedź", "%d odpowiedzi", "%d odpowiedzi"]
+//	}
+//
+// English text is used when translation is missing.
+package i18n
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultLocale is used when no other locale can be negotiated.
+const DefaultLocale = "en"
+
+// Locale describes language specific rules.
+type Locale struct {
+	Name string
+	// Plural return index of the plural form that should be used for
+	// given number.
+	Plural func(n int) int
+	// DateLayout is the time layout used to format dates. Month names are
+	// replaced with those of the locale.
+	DateLayout string
+	// DateTimeLayout is the time layout used to format full timestamps.
+	DateTimeLayout string
+	Months         [12]string
+}
+
+// locales are all supported locales. Catalogs are loaded only for these.
+var locales = map[string]*Locale{
+	"en": {
+		Name: "English",
+		Plural: func(n int) int {
+			if n == 1 {
+				return 0
+			}
+			return 1
+		},
+		DateLayout:     "_2 Jan 2006",
+		DateTimeLayout: "_2 Jan 2006 15:04 MST",
+	},
+	"pl": {
+		Name: "Polski",
+		Plural: func(n int) int {
+			switch {
+			case n == 1:
+				return 0
+			case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+				return 1
+			default:
+				return 2
+			}
+		},
+		DateLayout:     "_2 Jan 2006",
+		DateTimeLayout: "_2 Jan 2006, 15:04 MST",
+		Months: [12]string{
+			"sty", "lut", "mar", "kwi", "maj", "cze",
+			"lip", "sie", "wrz", "paź", "lis", "gru",
+		},
+	},
+}
+
+// entry is the translation of a single text, either a string or a list of
+// plural forms.
+type entry struct {
+	forms []string
+}
+
+func (e *entry) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		e.forms = []string{s}
+		return nil
+	}
+	return json.Unmarshal(b, &e.forms)
+}
+
+var catalogs = map[string]map[string]*entry{}
+
+// Load read message catalogs of all supported locales from given file
+// system. Catalog files are named after the locale, for example "pl.json".
+func Load(fsys fs.FS) error {
+	loaded := make(map[string]map[string]*entry)
+	for code := range locales {
+		raw, err := fs.ReadFile(fsys, code+".json")
+		if err != nil {
+			return fmt.Errorf("cannot read %q catalog: %s", code, err)
+		}
+		var catalog map[string]*entry
+		if err := json.Unmarshal(raw, &catalog); err != nil {
+			return fmt.Errorf("cannot decode %q catalog: %s", code, err)
+		}
+		loaded[code] = catalog
+	}
+	catalogs = loaded
+	return nil
+}
+
+// Locales return codes of all supported locales.
+func Locales() []string {
+	codes := make([]string, 0, len(locales))
+	for code := range locales {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// LocaleName return name of the locale in its own language.
+func LocaleName(code string) string {
+	if l, ok := locales[code]; ok {
+		return l.Name
+	}
+	return code
+}
+
+// IsLocale return true if given locale is supported.
+func IsLocale(code string) bool {
+	_, ok := locales[code]
+	return ok
+}
+
+// Translator translates texts into a single locale.
+type Translator struct {
+	code    string
+	locale  *Locale
+	catalog map[string]*entry
+}
+
+// NewTranslator return translator for given locale. Default locale is used
+// if given one is not supported.
+func NewTranslator(code string) *Translator {
+	l, ok := locales[code]
+	if !ok {
+		code, l = DefaultLocale, locales[DefaultLocale]
+	}
+	return &Translator{code: code, locale: l, catalog: catalogs[code]}
+}
+
+// Locale return code of the translator's locale.
+func (t *Translator) Locale() string {
+	return t.code
+}
+
+// T return translation of given text, formatted with given arguments.
+func (t *Translator) T(text string, args ...interface{}) string {
+	s := text
+	if e, ok := t.catalog[text]; ok && len(e.forms) != 0 {
+		s = e.forms[0]
+	}
+	if len(args) == 0 {
+		return s
+	}
+	return fmt.Sprintf(s, args...)
+}
+
+// N return translation of given text, in the plural form matching the
+// number. The number is the first format argument.
+func (t *Translator) N(text string, n int, args ...interface{}) string {
+	s := text
+	if e, ok := t.catalog[text]; ok && len(e.forms) != 0 {
+		i := t.locale.Plural(n)
+		if i >= len(e.forms) {
+			i = len(e.forms) - 1
+		}
+		s = e.forms[i]
+	}
+	return fmt.Sprintf(s, append([]interface{}{n}, args...)...)
+}
+
+// Message translate given message.
+func (t *Translator) Message(m *Message) string {
+	if m == nil {
+		return ""
+	}
+	return t.T(m.Text, m.Args...)
+}
+
+// Date return date formatted as customary for the locale.
+func (t *Translator) Date(tm time.Time) string {
+	return t.format(tm, t.locale.DateLayout)
+}
+
+// DateTime return full timestamp formatted as customary for the locale.
+func (t *Translator) DateTime(tm time.Time) string {
+	return t.format(tm, t.locale.DateTimeLayout)
+}
+
+// Ago return time elapsed since given time, relative to now, for example "3
+// minutes ago". Times older than a month are formatted as dates.
+func (t *Translator) Ago(tm, now time.Time) string {
+	d := now.Sub(tm)
+	switch {
+	case d < time.Minute:
+		return t.T("just now")
+	case d < time.Hour:
+		return t.N("%d minutes ago", int(d/time.Minute))
+	case d < 24*time.Hour:
+		return t.N("%d hours ago", int(d/time.Hour))
+	case d < 30*24*time.Hour:
+		return t.N("%d days ago", int(d/(24*time.Hour)))
+	default:
+		return t.Date(tm)
+	}
+}
+
+func (t *Translator) format(tm time.Time, layout string) string {
+	s := tm.Format(layout)
+	if t.locale.Months[0] != "" && strings.Contains(layout, "Jan") {
+		s = strings.Replace(s, tm.Month().String()[:3], t.locale.Months[tm.Month()-1], 1)
+	}
+	return s
+}
+
+// Message is a text that is translated only when displayed, together with
+// its format arguments.
+type Message struct {
+	Text string
+	Args []interface{}
+}
+
+// String return the message in English.
+func (m *Message) String() string {
+	if len(m.Args) == 0 {
+		return m.Text
+	}
+	return fmt.Sprintf(m.Text, m.Args...)
+}
+
+// MarshalText implements encoding.TextMarshaler, so that messages are
+// serialized as English text.
+func (m *Message) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// M return message for given text and format arguments.
+func M(text string, args ...interface{}) *Message {
+	return &Message{Text: text, Args: args}
+}
+
+// Negotiate return the locale that should be used. Preferred locale, if
+// supported, has priority over those listed in the Accept-Language header.
+func Negotiate(acceptLanguage, preferred string) string {
+	if IsLocale(preferred) {
+		return preferred
+	}
+	type lang struct {
+		code string
+		q    float64
+	}
+	var langs []lang
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		l := lang{code: strings.ToLower(strings.TrimSpace(fields[0])), q: 1}
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				fmt.Sscanf(param[2:], "%g", &l.q)
+			}
+		}
+		if l.code != "" && l.q > 0 {
+			langs = append(langs, l)
+		}
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+	for _, l := range langs {
+		// only the language is matched, region is ignored
+		code := strings.SplitN(l.code, "-", 2)[0]
+		if IsLocale(code) {
+			return code
+		}
+	}
+	return DefaultLocale
+}
@@ -0,0 +1,58 @@
+package i18n
+
+import "testing"
+
+func TestNegotiate(t *testing.T) {
+	cases := map[string]struct {
+		accept    string
+		preferred string
+		want      string
+	}{
+		"empty":                 {"", "", "en"},
+		"single":                {"pl", "", "pl"},
+		"region":                {"pl-PL", "", "pl"},
+		"upper case":            {"PL-pl", "", "pl"},
+		"unsupported":           {"de, fr", "", "en"},
+		"first supported":       {"de, pl, en", "", "pl"},
+		"q-value order":         {"en;q=0.5, pl;q=0.8", "", "pl"},
+		"default q-value":       {"en;q=0.9, pl", "", "pl"},
+		"equal q-values":        {"en;q=0.7, pl;q=0.7", "", "en"},
+		"zero q-value":          {"pl;q=0, en;q=0.1", "", "en"},
+		"invalid q-value":       {"pl;q=x", "", "pl"},
+		"spaces":                {" de ; q=0.9 , pl ; q=0.8 ", "", "pl"},
+		"wildcard":              {"*", "", "en"},
+		"preferred":             {"en", "pl", "pl"},
+		"unsupported preferred": {"pl", "de", "pl"},
+	}
+	for name, tc := range cases {
+		if got := Negotiate(tc.accept, tc.preferred); got != tc.want {
+			t.Errorf("%s: want %q, got %q", name, tc.want, got)
+		}
+	}
+}
+
+func TestPolishPlural(t *testing.T) {
+	cases := map[int]int{
+		0:   2,
+		1:   0,
+		2:   1,
+		4:   1,
+		5:   2,
+		11:  2,
+		12:  2,
+		14:  2,
+		21:  2,
+		22:  1,
+		25:  2,
+		101: 2,
+		102: 1,
+		112: 2,
+		122: 1,
+	}
+	plural := locales["pl"].Plural
+	for n, want := range cases {
+		if got := plural(n); got != want {
+			t.Errorf("%d: want form %d, got %d", n, want, got)
+		}
+	}
+}
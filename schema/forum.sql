@@ -7,6 +7,21 @@ CREATE TABLE IF NOT EXISTS users (
 );
 
 
+CREATE TABLE IF NOT EXISTS user_preferences (
+	user_id             integer PRIMARY KEY REFERENCES users(user_id),
+	topics_page_size    integer NOT NULL DEFAULT 0,
+	messages_page_size  integer NOT NULL DEFAULT 0,
+	theme               text NOT NULL DEFAULT '',
+	locale              text NOT NULL DEFAULT '',
+	timezone            text NOT NULL DEFAULT ''
+);
+
+-- columns added after the table was created
+ALTER TABLE user_preferences ADD COLUMN IF NOT EXISTS theme text NOT NULL DEFAULT '';
+ALTER TABLE user_preferences ADD COLUMN IF NOT EXISTS locale text NOT NULL DEFAULT '';
+ALTER TABLE user_preferences ADD COLUMN IF NOT EXISTS timezone text NOT NULL DEFAULT '';
+
+
 CREATE TABLE IF NOT EXISTS categories (
     category_id  serial PRIMARY KEY,
     name         text NOT NULL,
@@ -26,7 +41,21 @@ CREATE TABLE IF NOT EXISTS topics (
     views       integer NOT NULL DEFAULT 0
 );
 
-CREATE INDEX topics_updated_idx ON topics(updated);
+-- topics_updated_idx covered only the "updated" column
+DROP INDEX IF EXISTS topics_updated_idx;
+CREATE INDEX IF NOT EXISTS topics_updated_id_idx ON topics(updated, topic_id);
+CREATE INDEX IF NOT EXISTS topics_created_idx ON topics(created, topic_id);
+CREATE INDEX IF NOT EXISTS topics_replies_idx ON topics(replies, topic_id);
+CREATE INDEX IF NOT EXISTS topics_views_idx ON topics(views, topic_id);
+CREATE INDEX IF NOT EXISTS topics_author_idx ON topics(author_id);
+
+CREATE TABLE IF NOT EXISTS topic_tags (
+	topic_id  integer NOT NULL REFERENCES topics(topic_id),
+	tag       text NOT NULL,
+	PRIMARY KEY (topic_id, tag)
+);
+
+CREATE INDEX IF NOT EXISTS topic_tags_tag_idx ON topic_tags(tag);
 
 -- Update replies counter by inc/dec-rementing counter
 CREATE OR REPLACE FUNCTION update_category_on_topic_change()
@@ -63,10 +92,20 @@ CREATE TABLE IF NOT EXISTS messages (
 	topic_id   integer NOT NULL REFERENCES topics(topic_id),
 	author_id  integer NOT NULL REFERENCES users(user_id),
 	content    text NOT NULL,
+	-- content rendered to HTML by given version of markdown renderer
+	content_html    text NOT NULL DEFAULT '',
+	content_version integer NOT NULL DEFAULT 0,
+	reply_to_message_id integer REFERENCES messages(message_id),
 	created    timestamptz NOT NULL
 );
 
-CREATE INDEX messages_created_idx ON messages(created);
+-- columns added after the table was created
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS content_html text NOT NULL DEFAULT '';
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS content_version integer NOT NULL DEFAULT 0;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS reply_to_message_id integer REFERENCES messages(message_id);
+
+CREATE INDEX IF NOT EXISTS messages_created_idx ON messages(created);
+CREATE INDEX IF NOT EXISTS messages_topic_created_idx ON messages(topic_id, created, message_id);
 
 -- Update replies counter by counting all assigned messages and "updated" date
 CREATE OR REPLACE FUNCTION update_topic_on_messages_change()
@@ -97,4 +136,91 @@ CREATE TRIGGER update_topic_on_messages_change AFTER INSERT OR DELETE ON message
     FOR EACH ROW EXECUTE PROCEDURE update_topic_on_messages_change();
 
 
+CREATE TABLE IF NOT EXISTS attachments (
+	attachment_id serial PRIMARY KEY,
+	hash          text NOT NULL UNIQUE, -- SHA-256 of the content
+	content_type  text NOT NULL,
+	size          integer NOT NULL,
+	thumbnail     boolean NOT NULL DEFAULT false,
+	created       timestamptz NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS message_attachments (
+	message_id    integer NOT NULL REFERENCES messages(message_id),
+	attachment_id integer NOT NULL REFERENCES attachments(attachment_id),
+	name          text NOT NULL,
+	PRIMARY KEY (message_id, attachment_id)
+);
+
+
+CREATE TABLE IF NOT EXISTS notifications (
+	notification_id serial PRIMARY KEY,
+	user_id         integer NOT NULL REFERENCES users(user_id),
+	actor_id        integer NOT NULL REFERENCES users(user_id),
+	message_id      integer NOT NULL REFERENCES messages(message_id),
+	kind            text NOT NULL,
+	created         timestamptz NOT NULL,
+	seen            boolean NOT NULL DEFAULT false,
+	UNIQUE (user_id, message_id)
+);
+
+CREATE INDEX IF NOT EXISTS notifications_user_idx ON notifications(user_id, created);
+
+
+CREATE TABLE IF NOT EXISTS conversations (
+	conversation_id serial PRIMARY KEY,
+	subject         text NOT NULL,
+	created         timestamptz NOT NULL,
+	updated         timestamptz NOT NULL,
+	messages_count  integer NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS conversation_participants (
+	conversation_id integer NOT NULL REFERENCES conversations(conversation_id),
+	user_id         integer NOT NULL REFERENCES users(user_id),
+	last_read       timestamptz NOT NULL,
+	PRIMARY KEY (conversation_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS conversation_participants_user_idx ON conversation_participants(user_id);
+
+CREATE TABLE IF NOT EXISTS private_messages (
+	private_message_id serial PRIMARY KEY,
+	conversation_id    integer NOT NULL REFERENCES conversations(conversation_id),
+	author_id          integer NOT NULL REFERENCES users(user_id),
+	content            text NOT NULL,
+	created            timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS private_messages_conversation_idx ON private_messages(conversation_id, created);
+
+-- Update messages counter and "updated" date of the conversation
+CREATE OR REPLACE FUNCTION update_conversation_on_private_messages_change()
+RETURNS TRIGGER AS
+$$
+BEGIN
+    IF (TG_OP = 'INSERT') THEN
+        UPDATE conversations
+            SET
+                messages_count = (SELECT COUNT(*) FROM private_messages WHERE conversation_id = NEW.conversation_id),
+                updated = NEW.created
+            WHERE conversation_id = NEW.conversation_id;
+        RETURN NEW;
+    END IF;
+
+    UPDATE conversations
+        SET
+            messages_count = (SELECT COUNT(*) FROM private_messages WHERE conversation_id = OLD.conversation_id)
+        WHERE conversation_id = OLD.conversation_id;
+    RETURN OLD;
+
+END
+$$
+LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS update_conversation_on_private_messages_change ON private_messages;
+CREATE TRIGGER update_conversation_on_private_messages_change AFTER INSERT OR DELETE ON private_messages
+    FOR EACH ROW EXECUTE PROCEDURE update_conversation_on_private_messages_change();
+
+
 COMMIT;
@@ -0,0 +1,294 @@
+package tmpl
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/template/parse"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// dynamicTemplateLoader is used in development mode. Templates are parsed
+// again by Watch whenever any of them changes. Parsed templates are swapped
+// atomically, so rendering never waits for parsing.
+type dynamicTemplateLoader struct {
+	layers []fs.FS
+	funcs  template.FuncMap
+	state  atomic.Value // *loaderState
+}
+
+// loaderState is the result of the most recent parsing. If parsing failed,
+// the error is returned by every render until templates are fixed.
+type loaderState struct {
+	t     *template.Template
+	err   error
+	files map[string]templateFile
+}
+
+func newDynamicTemplateLoader(layers []fs.FS, funcs template.FuncMap) *dynamicTemplateLoader {
+	dl := &dynamicTemplateLoader{
+		layers: layers,
+		funcs:  funcs,
+	}
+	if err := dl.reload(); err != nil {
+		log.Printf("cannot parse templates: %s", err)
+	}
+	return dl
+}
+
+func (dl *dynamicTemplateLoader) reload() error {
+	t, err := parseTemplates(dl.layers, dl.funcs)
+	dl.state.Store(&loaderState{t: t, err: err, files: templateFiles(dl.layers)})
+	return err
+}
+
+func (dl *dynamicTemplateLoader) ExecuteTemplate(w io.Writer, name string, ctx interface{}) error {
+	state := dl.state.Load().(*loaderState)
+	if state.err != nil {
+		return state.err
+	}
+	err := state.t.ExecuteTemplate(w, name, ctx)
+	// html/template reports some of the syntax errors only when the
+	// template is executed for the first time
+	if terr, ok := err.(*template.Error); ok && terr.Name != "" {
+		if perr := executeError(state.files, terr); perr != nil {
+			return perr
+		}
+	}
+	return err
+}
+
+// executeError return parse error describing given execution error or nil if
+// the place of the error is not known. Error is reported for the defined
+// template, not for the file, so files are found by the names of the
+// templates they define.
+func executeError(files map[string]templateFile, terr *template.Error) *ParseError {
+	f, ok := files[terr.Name]
+	if !ok {
+		return nil
+	}
+	raw, err := fs.ReadFile(f.fsys, f.name)
+	if err != nil {
+		return nil
+	}
+	perr := &ParseError{File: f.name, Line: terr.Line, Msg: terr.Description, Err: terr}
+	if perr.Line == 0 && terr.Node != nil {
+		// node position is the byte offset within the file
+		if pos := int(terr.Node.Position()); pos <= len(raw) {
+			perr.Line = 1 + bytes.Count(raw[:pos], []byte("\n"))
+		}
+	}
+	if perr.Line == 0 {
+		return nil
+	}
+	perr.Source = sourceAround(f.fsys, f.name, perr.Line)
+	return perr
+}
+
+// templateFile is the file a template is defined in.
+type templateFile struct {
+	fsys fs.FS
+	name string
+}
+
+// templateFiles return files defining every template of given layers.
+// Templates redefined by later layers are defined by the last of the files.
+func templateFiles(layers []fs.FS) map[string]templateFile {
+	files := make(map[string]templateFile)
+	for _, fsys := range layers {
+		paths, err := fs.Glob(fsys, "*html")
+		if err != nil {
+			continue
+		}
+		for _, path := range paths {
+			raw, err := fs.ReadFile(fsys, path)
+			if err != nil {
+				continue
+			}
+			// functions are checked when the templates are parsed
+			tree := parse.New(path)
+			tree.Mode = parse.SkipFuncCheck
+			trees := make(map[string]*parse.Tree)
+			if _, err := tree.Parse(string(raw), "", "", trees); err != nil {
+				continue
+			}
+			for name := range trees {
+				files[name] = templateFile{fsys: fsys, name: path}
+			}
+		}
+	}
+	return files
+}
+
+// Watch parse templates again whenever any HTML file in given directories
+// changes. Only templates loaded in development mode are parsed again.
+//
+// Directories are watched instead of files, because many editors save a file
+// by replacing it with a new one.
+func Watch(dirs ...string) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if err := w.Add(dir); err != nil {
+			w.Close()
+			return fmt.Errorf("cannot watch %q: %s", dir, err)
+		}
+	}
+	go watch(w)
+	return nil
+}
+
+func watch(w *fsnotify.Watcher) {
+	// a single save often produces several events, so templates are
+	// parsed only once the changes settle
+	var reload <-chan time.Time
+	for {
+		select {
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if strings.HasSuffix(ev.Name, "html") {
+				reload = time.After(100 * time.Millisecond)
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("template watcher error: %s", err)
+		case <-reload:
+			reload = nil
+			reloadTemplates()
+		}
+	}
+}
+
+// reloadTemplates parse again templates of all themes and locales.
+func reloadTemplates() {
+	failed := make(map[string]bool)
+	for _, t := range templates {
+		dl, ok := t.(*dynamicTemplateLoader)
+		if !ok {
+			continue
+		}
+		// the same error is usually reported by every template set
+		if err := dl.reload(); err != nil && !failed[err.Error()] {
+			failed[err.Error()] = true
+			log.Printf("cannot parse templates: %s", err)
+		}
+	}
+	if len(failed) == 0 {
+		log.Printf("templates reloaded")
+	}
+}
+
+// ParseError is a template syntax error, together with the source code
+// around the invalid line.
+type ParseError struct {
+	File   string
+	Line   int
+	Msg    string
+	Source []SourceLine
+	Err    error
+}
+
+// SourceLine is a single line of template source code.
+type SourceLine struct {
+	Number  int
+	Text    string
+	Invalid bool
+}
+
+func (e *ParseError) Error() string {
+	return e.Err.Error()
+}
+
+// parseErrRx match error messages of text/template parser, for example
+//
+//	template: page_topic_list.html:12: unexpected "}" in operand
+var parseErrRx = regexp.MustCompile(`^template: ([^:]+):(\d+):(?:\d+:)? (.*)$`)
+
+// newParseError return parse error of template from given file system.
+func newParseError(fsys fs.FS, err error) *ParseError {
+	perr := &ParseError{Msg: err.Error(), Err: err}
+	m := parseErrRx.FindStringSubmatch(err.Error())
+	if m == nil {
+		return perr
+	}
+	perr.File, perr.Msg = m[1], m[3]
+	perr.Line, _ = strconv.Atoi(m[2])
+	perr.Source = sourceAround(fsys, perr.File, perr.Line)
+	return perr
+}
+
+// sourceAround return few lines of the file around given line, or nil if
+// the file cannot be read.
+func sourceAround(fsys fs.FS, name string, line int) []SourceLine {
+	const context = 5
+
+	raw, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil
+	}
+	var lines []SourceLine
+	s := bufio.NewScanner(bytes.NewReader(raw))
+	for n := 1; s.Scan(); n++ {
+		if n < line-context {
+			continue
+		}
+		if n > line+context {
+			break
+		}
+		lines = append(lines, SourceLine{Number: n, Text: s.Text(), Invalid: n == line})
+	}
+	return lines
+}
+
+// renderParseError write page describing the template error. It does not
+// use any of the forum templates, because they may be the broken ones.
+func renderParseError(w http.ResponseWriter, perr *ParseError) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	if err := parseErrorTmpl.Execute(w, perr); err != nil {
+		log.Printf("cannot render template error page: %s", err)
+	}
+}
+
+var parseErrorTmpl = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>Template error</title>
+	<style>
+		body { font-family: sans-serif; margin: 2em; }
+		pre { background: #f7f7f9; padding: 1em; }
+		.invalid { background: #f2dede; }
+	</style>
+</head>
+<body>
+	<h1>Template error</h1>
+	<p>
+		{{if .File}}<strong>{{.File}}:{{.Line}}</strong>{{end}}
+		{{.Msg}}
+	</p>
+	{{with .Source}}
+		<pre>{{range .}}<span{{if .Invalid}} class="invalid"{{end}}>{{printf "%4d" .Number}}  {{.Text}}</span>
+{{end}}</pre>
+	{{end}}
+	<p><small>Templates are reloaded automatically once the file is saved.</small></p>
+</body>
+</html>
+`))
@@ -0,0 +1,57 @@
+package tmpl
+
+import (
+	"bytes"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestTemplateFiles(t *testing.T) {
+	base := fstest.MapFS{
+		"page_header.html": {Data: []byte(`{{define "page_header"}}header{{end}}
+{{define "page_navbar"}}navbar{{end}}`)},
+		"page_error.html": {Data: []byte(`{{define "page_error"}}{{.}}{{end}}`)},
+	}
+	theme := fstest.MapFS{
+		"navbar.html": {Data: []byte(`{{define "page_navbar"}}themed{{end}}`)},
+	}
+	files := templateFiles([]fs.FS{base, theme})
+
+	for name, want := range map[string]string{
+		"page_header": "page_header.html",
+		"page_navbar": "navbar.html",
+		"page_error":  "page_error.html",
+	} {
+		if got := files[name].name; got != want {
+			t.Errorf("%s: want %q file, got %q", name, want, got)
+		}
+	}
+}
+
+func TestDynamicTemplateLoaderExecuteError(t *testing.T) {
+	fsys := fstest.MapFS{
+		"page_broken.html": {Data: []byte(`{{define "page_ok"}}ok{{end}}
+
+{{define "page_broken"}}
+	{{if .}}<a href="{{end}}x">
+{{end}}`)},
+	}
+	dl := newDynamicTemplateLoader([]fs.FS{fsys}, nil)
+
+	var b bytes.Buffer
+	err := dl.ExecuteTemplate(&b, "page_broken", "x")
+	perr, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("want parse error, got %#v", err)
+	}
+	if perr.File != "page_broken.html" {
+		t.Errorf("want error in page_broken.html, got %q", perr.File)
+	}
+	if perr.Line != 4 {
+		t.Errorf("want error in line 4, got %d", perr.Line)
+	}
+	if len(perr.Source) == 0 {
+		t.Error("no source code")
+	}
+}
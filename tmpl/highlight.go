@@ -0,0 +1,38 @@
+package tmpl
+
+import (
+	"bytes"
+	"html"
+	"strings"
+
+	"github.com/russross/blackfriday"
+	"github.com/sourcegraph/syntaxhighlight"
+)
+
+// highlightRenderer is markdown renderer that highlights syntax of code
+// blocks. Highlighting is language agnostic, language provided with the
+// fenced code block is only exposed as "language-<name>" class.
+type highlightRenderer struct {
+	blackfriday.Renderer
+}
+
+func (r *highlightRenderer) BlockCode(out *bytes.Buffer, text []byte, lang string) {
+	code, err := syntaxhighlight.AsHTML(text)
+	if err != nil {
+		r.Renderer.BlockCode(out, text, lang)
+		return
+	}
+
+	if out.Len() > 0 {
+		out.WriteByte('\n')
+	}
+	out.WriteString("<pre><code")
+	if fields := strings.Fields(lang); len(fields) > 0 {
+		out.WriteString(` class="language-`)
+		out.WriteString(html.EscapeString(fields[0]))
+		out.WriteString(`"`)
+	}
+	out.WriteString(">")
+	out.Write(code)
+	out.WriteString("</code></pre>\n")
+}
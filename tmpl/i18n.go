@@ -0,0 +1,72 @@
+package tmpl
+
+import (
+	"fmt"
+	"html/template"
+	"reflect"
+	"time"
+
+	"github.com/husio/bb/i18n"
+)
+
+// localeFuncs return template functions translating texts using given
+// translator:
+//
+//	{{t "New topic"}}
+//	{{t "by %s" .User.Login}}
+//	{{t .TitleErr}}            translate *i18n.Message
+//	{{tn "%d replies" .Replies}}
+//	{{date .Created $.Header.Location}}
+//	{{ago .Created $.Header.Location}}
+func localeFuncs(tr *i18n.Translator) template.FuncMap {
+	return template.FuncMap{
+		"t": func(text interface{}, args ...interface{}) string {
+			switch text := text.(type) {
+			case *i18n.Message:
+				return tr.Message(text)
+			case string:
+				return tr.T(text, args...)
+			default:
+				return fmt.Sprint(text)
+			}
+		},
+		"tn": func(text string, n interface{}, args ...interface{}) string {
+			return tr.N(text, toInt(n), args...)
+		},
+		"date": func(t time.Time, loc ...*time.Location) string {
+			return tr.Date(inLocation(t, loc))
+		},
+		"datetime": func(t time.Time, loc ...*time.Location) string {
+			return tr.DateTime(inLocation(t, loc))
+		},
+		"ago": func(t time.Time, loc ...*time.Location) template.HTML {
+			t = inLocation(t, loc)
+			return template.HTML(fmt.Sprintf(`<time datetime="%s" title="%s">%s</time>`,
+				t.UTC().Format(time.RFC3339),
+				template.HTMLEscapeString(tr.DateTime(t)),
+				template.HTMLEscapeString(tr.Ago(t, time.Now()))))
+		},
+		"locale": tr.Locale,
+	}
+}
+
+// inLocation return time in the first of given locations, or in the server
+// location if none is given.
+func inLocation(t time.Time, loc []*time.Location) time.Time {
+	if len(loc) == 0 || loc[0] == nil {
+		return t.Local()
+	}
+	return t.In(loc[0])
+}
+
+// toInt return integer value of any integer type.
+func toInt(n interface{}) int {
+	v := reflect.ValueOf(n)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int(v.Uint())
+	}
+	return 0
+}
@@ -0,0 +1,38 @@
+package tmpl
+
+import (
+	"bytes"
+	"html/template"
+	"testing"
+	"time"
+
+	"github.com/husio/bb/i18n"
+)
+
+func TestDateInLocation(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skipf("no timezone database: %s", err)
+	}
+	// late evening in UTC is the next day in Tokyo
+	created := time.Date(2016, 3, 1, 22, 0, 0, 0, time.UTC)
+
+	tr := i18n.NewTranslator("en")
+	if tr.Date(created) == tr.Date(created.In(tokyo)) {
+		t.Fatal("test date is the same in both locations")
+	}
+
+	tmpl := template.Must(template.New("").Funcs(localeFuncs(tr)).Parse(
+		`{{date .Created .Location}}|{{datetime .Created .Location}}`))
+	var b bytes.Buffer
+	err = tmpl.Execute(&b, struct {
+		Created  time.Time
+		Location *time.Location
+	}{created, tokyo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := tr.Date(created.In(tokyo)) + "|" + tr.DateTime(created.In(tokyo)); b.String() != want {
+		t.Fatalf("want %q, got %q", want, b.String())
+	}
+}
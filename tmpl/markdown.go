@@ -0,0 +1,239 @@
+package tmpl
+
+import (
+	"bytes"
+	"html/template"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/russross/blackfriday"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// markdown render given markdown content as safe HTML. Rendering is done in
+// three steps: markdown is converted to HTML, the result is sanitized and
+// finally sanitized document is extended with elements that are not allowed
+// in the user input, like task list checkboxes.
+func markdown(s string) template.HTML {
+	safe := renderMarkdown(s)
+	return template.HTML(linkMentions(decorate(safe)))
+}
+
+// renderMarkdown return sanitized HTML for given markdown content.
+func renderMarkdown(s string) []byte {
+	renderer := &highlightRenderer{
+		Renderer: blackfriday.HtmlRendererWithParameters(mdHTMLFlags, "", "", blackfriday.HtmlRendererParameters{
+			HeaderIDPrefix: headerIDPrefix,
+		}),
+	}
+	unsafe := blackfriday.MarkdownOptions([]byte(s), renderer, blackfriday.Options{
+		Extensions: mdExtensions,
+	})
+	return mdPolicy.SanitizeBytes(unsafe)
+}
+
+const (
+	mdHTMLFlags = blackfriday.HTML_USE_XHTML |
+		blackfriday.HTML_USE_SMARTYPANTS |
+		blackfriday.HTML_SMARTYPANTS_FRACTIONS |
+		blackfriday.HTML_SMARTYPANTS_DASHES |
+		blackfriday.HTML_SMARTYPANTS_LATEX_DASHES
+
+	mdExtensions = blackfriday.EXTENSION_NO_INTRA_EMPHASIS |
+		blackfriday.EXTENSION_TABLES |
+		blackfriday.EXTENSION_FENCED_CODE |
+		blackfriday.EXTENSION_AUTOLINK |
+		blackfriday.EXTENSION_STRIKETHROUGH |
+		blackfriday.EXTENSION_SPACE_HEADERS |
+		blackfriday.EXTENSION_HEADER_IDS |
+		blackfriday.EXTENSION_AUTO_HEADER_IDS |
+		blackfriday.EXTENSION_BACKSLASH_LINE_BREAK |
+		blackfriday.EXTENSION_DEFINITION_LISTS
+
+	// headerIDPrefix is used by all generated header IDs, so that they do not
+	// collide with IDs used by the page itself
+	headerIDPrefix = "h-"
+)
+
+// mdPolicy is the user generated content policy, extended to allow the class
+// attributes required by syntax highlighting. Links are not marked as
+// nofollow by the sanitizer, because only external links should be.
+var mdPolicy = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.RequireNoFollowOnLinks(false)
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#-]+$`)).OnElements("code")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^(str|kwd|com|typ|lit|pun|pln|tag|htm|atn|atv|dec)$`)).OnElements("span")
+	return p
+}()
+
+// decorate return given sanitized HTML document extended with task list
+// checkboxes, spoiler blocks, header anchors and with external links marked
+// as user generated content.
+func decorate(b []byte) []byte {
+	nodes, err := html.ParseFragment(bytes.NewReader(b), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "body",
+		DataAtom: atom.Body,
+	})
+	if err != nil {
+		return b
+	}
+	var out bytes.Buffer
+	for _, n := range nodes {
+		decorateNode(n)
+		if err := html.Render(&out, n); err != nil {
+			return b
+		}
+	}
+	return out.Bytes()
+}
+
+func decorateNode(n *html.Node) {
+	if n.Type == html.ElementNode {
+		if id := attr(n, "id"); id != "" && !strings.HasPrefix(id, headerIDPrefix) {
+			// only generated IDs are allowed, because user provided ones
+			// could clash with those used by the page
+			removeAttr(n, "id")
+		}
+
+		switch n.DataAtom {
+		case atom.A:
+			if u, err := url.Parse(attr(n, "href")); err == nil && u.Host != "" {
+				setAttr(n, "rel", "nofollow ugc")
+			}
+		case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+			if id := attr(n, "id"); id != "" {
+				anchor := &html.Node{
+					Type:     html.ElementNode,
+					Data:     "a",
+					DataAtom: atom.A,
+					Attr: []html.Attribute{
+						{Key: "href", Val: "#" + id},
+						{Key: "class", Val: "anchor"},
+					},
+				}
+				anchor.AppendChild(&html.Node{Type: html.TextNode, Data: "#"})
+				n.AppendChild(anchor)
+			}
+		case atom.Li:
+			decorateTaskItem(n)
+		case atom.Blockquote:
+			decorateSpoiler(n)
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		decorateNode(c)
+	}
+}
+
+// decorateTaskItem convert list item starting with "[ ]" or "[x]" into a
+// task list item with a checkbox.
+func decorateTaskItem(li *html.Node) {
+	text := li.FirstChild
+	if text != nil && text.DataAtom == atom.P {
+		// list items separated by blank lines have their content wrapped
+		// in paragraphs
+		text = text.FirstChild
+	}
+	if text == nil || text.Type != html.TextNode {
+		return
+	}
+	var checked bool
+	switch {
+	case strings.HasPrefix(text.Data, "[ ] "):
+	case strings.HasPrefix(text.Data, "[x] "), strings.HasPrefix(text.Data, "[X] "):
+		checked = true
+	default:
+		return
+	}
+	text.Data = text.Data[3:]
+
+	checkbox := &html.Node{
+		Type:     html.ElementNode,
+		Data:     "input",
+		DataAtom: atom.Input,
+		Attr: []html.Attribute{
+			{Key: "type", Val: "checkbox"},
+			{Key: "disabled"},
+		},
+	}
+	if checked {
+		checkbox.Attr = append(checkbox.Attr, html.Attribute{Key: "checked"})
+	}
+	text.Parent.InsertBefore(checkbox, text)
+	setAttr(li, "class", "task-list-item")
+}
+
+// decorateSpoiler convert blockquote with all lines starting with "!", for
+// example
+//
+//	>! Hidden content
+//
+// into a collapsed spoiler block.
+func decorateSpoiler(bq *html.Node) {
+	p := bq.FirstChild
+	for p != nil && p.Type == html.TextNode && strings.TrimSpace(p.Data) == "" {
+		p = p.NextSibling
+	}
+	if p == nil || p.DataAtom != atom.P || p.FirstChild == nil || p.FirstChild.Type != html.TextNode {
+		return
+	}
+	if !strings.HasPrefix(p.FirstChild.Data, "!") {
+		return
+	}
+
+	var strip func(*html.Node)
+	strip = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			n.Data = spoilerrx.ReplaceAllString(n.Data, "$1")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			strip(c)
+		}
+	}
+	strip(bq)
+
+	bq.Data = "details"
+	bq.DataAtom = atom.Details
+	setAttr(bq, "class", "spoiler")
+	summary := &html.Node{
+		Type:     html.ElementNode,
+		Data:     "summary",
+		DataAtom: atom.Summary,
+	}
+	summary.AppendChild(&html.Node{Type: html.TextNode, Data: "Spoiler"})
+	bq.InsertBefore(summary, bq.FirstChild)
+}
+
+var spoilerrx = regexp.MustCompile(`(^|\n)! ?`)
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func setAttr(n *html.Node, key, val string) {
+	for i, a := range n.Attr {
+		if a.Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
+
+func removeAttr(n *html.Node, key string) {
+	for i, a := range n.Attr {
+		if a.Key == key {
+			n.Attr = append(n.Attr[:i], n.Attr[i+1:]...)
+			return
+		}
+	}
+}
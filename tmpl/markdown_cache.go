@@ -0,0 +1,82 @@
+package tmpl
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"html/template"
+	"sync"
+)
+
+// MarkdownVersion is the version of the markdown rendering pipeline. It must
+// be incremented with every change that affects rendered HTML, so that all
+// stored, pre-rendered content is rendered again.
+const MarkdownVersion = 1
+
+// RenderMarkdown return sanitized HTML for given markdown content, exactly as
+// it is rendered by the markdown template function. Result is not cached.
+func RenderMarkdown(s string) string {
+	return string(markdown(s))
+}
+
+// Markdown return sanitized HTML for given markdown content. This is the
+// markdown template function.
+func Markdown(s string) template.HTML {
+	return mdCache.Render(s)
+}
+
+// mdCache keeps HTML of recently rendered markdown content, so that content
+// without pre-rendered HTML is not rendered on every page display.
+var mdCache = newMarkdownCache(2048)
+
+type markdownCache struct {
+	mu    sync.Mutex
+	size  int
+	items map[[sha256.Size]byte]*list.Element
+	lru   *list.List
+}
+
+type markdownCacheItem struct {
+	key  [sha256.Size]byte
+	html template.HTML
+}
+
+func newMarkdownCache(size int) *markdownCache {
+	return &markdownCache{
+		size:  size,
+		items: make(map[[sha256.Size]byte]*list.Element),
+		lru:   list.New(),
+	}
+}
+
+// Render return HTML for given markdown content, using cached result if
+// available.
+func (c *markdownCache) Render(s string) template.HTML {
+	key := sha256.Sum256([]byte(s))
+
+	c.mu.Lock()
+	if el, ok := c.items[key]; ok {
+		c.lru.MoveToFront(el)
+		html := el.Value.(*markdownCacheItem).html
+		c.mu.Unlock()
+		return html
+	}
+	c.mu.Unlock()
+
+	// render without holding the lock, so that rendering of different
+	// content is not serialized
+	html := markdown(s)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.lru.MoveToFront(el)
+		return html
+	}
+	c.items[key] = c.lru.PushFront(&markdownCacheItem{key: key, html: html})
+	for c.lru.Len() > c.size {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.items, el.Value.(*markdownCacheItem).key)
+	}
+	return html
+}
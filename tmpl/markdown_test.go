@@ -0,0 +1,46 @@
+package tmpl
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "Update golden files")
+
+// TestMarkdown render every testdata/*.md file and compare the result with
+// the corresponding *.html file. Run with -update flag to write the current
+// results into golden files.
+func TestMarkdown(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no test files")
+	}
+	for _, path := range paths {
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := string(markdown(string(src)))
+
+		golden := strings.TrimSuffix(path, ".md") + ".html"
+		if *update {
+			if err := ioutil.WriteFile(golden, []byte(got), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != string(want) {
+			t.Errorf("%s: unexpected result\nwant:\n%s\ngot:\n%s", path, want, got)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package tmpl
+
+import (
+	"bytes"
+	"regexp"
+
+	"golang.org/x/net/html"
+)
+
+// Mentions return unique user logins mentioned in given markdown content.
+// Mentions placed inside of links or code blocks are ignored, so that the
+// result is always the same as the list of profile links rendered by the
+// markdown template function.
+func Mentions(s string) []string {
+	var logins []string
+	seen := make(map[string]bool)
+	eachMentionText(renderMarkdown(s), func(text []byte) []byte {
+		for _, m := range mentionrx.FindAllSubmatch(text, -1) {
+			login := string(m[2])
+			if !seen[login] {
+				seen[login] = true
+				logins = append(logins, login)
+			}
+		}
+		return text
+	})
+	return logins
+}
+
+// linkMentions return given HTML document with all mentions replaced by
+// links to mentioned user's profile page.
+func linkMentions(b []byte) []byte {
+	return eachMentionText(b, func(text []byte) []byte {
+		return mentionrx.ReplaceAll(text, []byte(`$1<a href="/@$2" class="mention">@$2</a>`))
+	})
+}
+
+// eachMentionText call fn for every text node of given HTML document that can
+// contain a mention and return document with those text nodes replaced by fn
+// result.
+func eachMentionText(b []byte, fn func([]byte) []byte) []byte {
+	var out bytes.Buffer
+	z := html.NewTokenizer(bytes.NewReader(b))
+	skip := 0
+	for {
+		switch tt := z.Next(); tt {
+		case html.ErrorToken:
+			return out.Bytes()
+		case html.StartTagToken, html.EndTagToken:
+			if name, _ := z.TagName(); mentionSkipTags[string(name)] {
+				if tt == html.StartTagToken {
+					skip++
+				} else if skip > 0 {
+					skip--
+				}
+			}
+			out.Write(z.Raw())
+		case html.TextToken:
+			if skip == 0 {
+				out.Write(fn(z.Raw()))
+			} else {
+				out.Write(z.Raw())
+			}
+		default:
+			out.Write(z.Raw())
+		}
+	}
+}
+
+var mentionSkipTags = map[string]bool{
+	"a":    true,
+	"code": true,
+	"pre":  true,
+}
+
+var mentionrx = regexp.MustCompile(`(^|[^\w@/.-])@([\w-]{1,64})`)
@@ -0,0 +1,90 @@
+package tmpl
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/husio/bb/i18n"
+)
+
+// Negotiate return response writer that must be used instead of given one,
+// so that Render functions respond with JSON instead of HTML when the client
+// prefers it. Rendered is the same context that is passed to the template.
+// Error pages are rendered in the language negotiated with the client.
+func Negotiate(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	w.Header().Add("Vary", "Accept")
+	return &negotiatedResponseWriter{
+		ResponseWriter: w,
+		json:           prefersJSON(r.Header.Get("Accept")),
+		locale:         i18n.Negotiate(r.Header.Get("Accept-Language"), ""),
+	}
+}
+
+type negotiatedResponseWriter struct {
+	http.ResponseWriter
+	json   bool
+	locale string
+}
+
+// respondJSON return true if the response should be serialized as JSON.
+func respondJSON(w http.ResponseWriter) bool {
+	nw, ok := w.(*negotiatedResponseWriter)
+	return ok && nw.json
+}
+
+// responseLocale return the locale negotiated for the response.
+func responseLocale(w http.ResponseWriter) string {
+	if nw, ok := w.(*negotiatedResponseWriter); ok {
+		return nw.locale
+	}
+	return i18n.DefaultLocale
+}
+
+// prefersJSON return true if Accept header value prefers JSON over HTML.
+func prefersJSON(accept string) bool {
+	var jsonQ, htmlQ float64
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		switch strings.TrimSpace(fields[0]) {
+		case "application/json":
+			if q > jsonQ {
+				jsonQ = q
+			}
+		case "text/html":
+			if q > htmlQ {
+				htmlQ = q
+			}
+		}
+	}
+	return jsonQ > 0 && jsonQ > htmlQ
+}
+
+// renderJSON write the context serialized as JSON.
+func renderJSON(w http.ResponseWriter, code int, context interface{}) {
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(context); err != nil {
+		log.Printf("cannot serialize %T: %s", context, err)
+		code = http.StatusInternalServerError
+		b.Reset()
+		json.NewEncoder(&b).Encode(errcontext{
+			Code: code,
+			Text: http.StatusText(code),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	b.WriteTo(w)
+}
@@ -0,0 +1,29 @@
+package tmpl
+
+import "testing"
+
+func TestPrefersJSON(t *testing.T) {
+	cases := map[string]struct {
+		accept string
+		want   bool
+	}{
+		"empty":              {"", false},
+		"json":               {"application/json", true},
+		"html":               {"text/html", false},
+		"browser":            {"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", false},
+		"any":                {"*/*", false},
+		"json first":         {"application/json, text/html", false},
+		"json preferred":     {"text/html;q=0.5, application/json", true},
+		"html preferred":     {"application/json;q=0.5, text/html", false},
+		"json not accepted":  {"application/json;q=0", false},
+		"spaces":             {" text/html ; q=0.1 , application/json ; q=0.2 ", true},
+		"invalid q-value":    {"application/json;q=x, text/html;q=0.5", true},
+		"json with charset":  {"application/json;charset=utf-8", true},
+		"highest json value": {"application/json;q=0.1, text/html;q=0.5, application/json", true},
+	}
+	for name, tc := range cases {
+		if got := prefersJSON(tc.accept); got != tc.want {
+			t.Errorf("%s: want %v, got %v", name, tc.want, got)
+		}
+	}
+}
@@ -2,156 +2,244 @@ package tmpl
 
 import (
 	"bytes"
+	"fmt"
 	"html/template"
 	"io"
+	"io/fs"
 	"log"
 	"net/http"
 	"os"
-	"sync"
-	"time"
+	"sort"
 
-	"github.com/microcosm-cc/bluemonday"
-	"github.com/russross/blackfriday"
+	"github.com/husio/bb/i18n"
 )
 
-var tmpl interface {
+type executor interface {
 	ExecuteTemplate(io.Writer, string, interface{}) error
 }
 
+var (
+	// templates is the template set of every installed theme and locale
+	// combination, see setKey
+	templates    map[string]executor
+	themes       []string
+	defaultTheme string
+)
+
+func setKey(theme, locale string) string {
+	return theme + "/" + locale
+}
+
 var tNoCache = os.Getenv("DEV") == "1"
 
-func LoadTemplates() error {
-	const tmplglob = "assets/templates/*html"
+// Theme is a set of templates and a stylesheet layered over the default
+// templates. Templates are parsed after the default ones, so that they can
+// redefine any of them. All fields but the name are optional.
+type Theme struct {
+	Name      string
+	Templates fs.FS
+	// CSS is the name of the static file included by every page after
+	// the default stylesheets.
+	CSS string
+}
+
+// LoadTemplates parse all HTML templates from given file system, once for
+// every theme. If the override directory is not empty, HTML templates found
+// in it are parsed last, so that they can redefine templates of any theme.
+// Default theme is used to render pages for which no theme was chosen.
+func LoadTemplates(fsys fs.FS, overrideDir string, installed []*Theme, defaultName string) error {
+	loaded := make(map[string]executor)
+	var names []string
+	for _, theme := range installed {
+		layers := []fs.FS{fsys}
+		if theme.Templates != nil {
+			layers = append(layers, theme.Templates)
+		}
+		if overrideDir != "" {
+			layers = append(layers, os.DirFS(overrideDir))
+		}
+
+		for _, locale := range i18n.Locales() {
+			funcs := themeFuncs(theme)
+			for name, fn := range localeFuncs(i18n.NewTranslator(locale)) {
+				funcs[name] = fn
+			}
 
-	var err error
-	if tNoCache {
-		tmpl, err = newDynamicTemplateLoader(tmplglob)
-	} else {
-		t := template.New("").Funcs(tmplFuncs)
-		tmpl, err = t.ParseGlob(tmplglob)
+			if tNoCache {
+				// templates are parsed again when changed, so that broken
+				// templates can be fixed without restarting the server
+				loaded[setKey(theme.Name, locale)] = newDynamicTemplateLoader(layers, funcs)
+				continue
+			}
+			t, err := parseTemplates(layers, funcs)
+			if err != nil {
+				return fmt.Errorf("theme %q: %s", theme.Name, err)
+			}
+			loaded[setKey(theme.Name, locale)] = t
+		}
+		names = append(names, theme.Name)
+	}
+	if _, ok := loaded[setKey(defaultName, i18n.DefaultLocale)]; !ok {
+		return fmt.Errorf("default theme %q is not installed", defaultName)
 	}
-	return err
+	sort.Strings(names)
+	templates = loaded
+	themes = names
+	defaultTheme = defaultName
+	return nil
 }
 
-type dynamicTemplateLoader struct {
-	mu   sync.Mutex
-	glob string
-	t    *template.Template
+// Themes return names of all installed themes.
+func Themes() []string {
+	return themes
 }
 
-func newDynamicTemplateLoader(glob string) (*dynamicTemplateLoader, error) {
-	t, err := template.New("").Funcs(tmplFuncs).ParseGlob(glob)
-	if err != nil {
-		return nil, err
-	}
-	dl := &dynamicTemplateLoader{
-		glob: glob,
-		t:    t,
-	}
-	go dl.hotUpdate()
-	return dl, nil
+// Themed is implemented by template contexts that choose the theme they are
+// rendered with.
+type Themed interface {
+	TemplateTheme() string
 }
 
-func (dl *dynamicTemplateLoader) hotUpdate() {
-	dir := dl.glob
-	for len(dir) > 0 && dir[len(dir)-1] != '/' {
-		dir = dir[:len(dir)-1]
-	}
-	if len(dir) == 0 {
-		panic("invalid directory")
-	}
+// Localized is implemented by template contexts that choose the locale they
+// are rendered in.
+type Localized interface {
+	TemplateLocale() string
+}
 
-	lastMod := time.Now()
-	for {
-		time.Sleep(2 * time.Second)
+// themeFuncs return template functions specific to given theme.
+func themeFuncs(theme *Theme) template.FuncMap {
+	return template.FuncMap{
+		"theme": func() string {
+			return theme.Name
+		},
+		"themeCSS": func() string {
+			if theme.CSS == "" {
+				return ""
+			}
+			return AssetURL(theme.CSS)
+		},
+	}
+}
 
-		f, err := os.Stat(dir)
+// parseTemplates parse HTML templates of all given file systems, in order.
+func parseTemplates(layers []fs.FS, funcs template.FuncMap) (*template.Template, error) {
+	t := template.New("").Funcs(tmplFuncs).Funcs(funcs)
+	for _, fsys := range layers {
+		paths, err := fs.Glob(fsys, "*html")
 		if err != nil {
-			log.Printf("cannot stat %q directory: %s", dir, err)
+			return nil, err
+		}
+		if len(paths) == 0 {
 			continue
 		}
-
-		if mtime := f.ModTime(); mtime.After(lastMod) {
-			dl.mu.Lock()
-			t := template.New("").Funcs(tmplFuncs)
-			if t, err := t.ParseGlob(dl.glob); err != nil {
-				log.Printf("cannot parse templates: %s", err)
-			} else {
-				dl.t = t
-			}
-			dl.mu.Unlock()
-			lastMod = mtime
+		if t, err = t.ParseFS(fsys, paths...); err != nil {
+			return nil, newParseError(fsys, err)
 		}
 	}
+	return t, nil
 }
 
-func (dl *dynamicTemplateLoader) ExecuteTemplate(w io.Writer, name string, ctx interface{}) error {
-	dl.mu.Lock()
-	defer dl.mu.Unlock()
-	return dl.t.ExecuteTemplate(w, name, ctx)
-}
-
+// renderTo render template using the theme and the locale chosen by the
+// context or the default ones.
 func renderTo(w io.Writer, name string, context interface{}) error {
-	return tmpl.ExecuteTemplate(w, name, context)
+	theme, locale := defaultTheme, i18n.DefaultLocale
+	if c, ok := context.(Themed); ok && c.TemplateTheme() != "" {
+		theme = c.TemplateTheme()
+	}
+	if c, ok := context.(Localized); ok && c.TemplateLocale() != "" {
+		locale = c.TemplateLocale()
+	}
+	t, ok := templates[setKey(theme, locale)]
+	if !ok {
+		if t, ok = templates[setKey(defaultTheme, locale)]; !ok {
+			t, ok = templates[setKey(defaultTheme, i18n.DefaultLocale)]
+		}
+	}
+	if !ok {
+		return fmt.Errorf("no templates loaded")
+	}
+	return t.ExecuteTemplate(w, name, context)
 }
 
 type errcontext struct {
-	Code int
-	Text string
+	Code   int    `json:"code"`
+	Text   string `json:"error"`
+	Locale string `json:"-"`
+}
+
+func (c errcontext) TemplateLocale() string {
+	return c.Locale
 }
 
 func Render500(w http.ResponseWriter, err error) {
 	log.Printf("error: %s", err)
-	ctx := errcontext{
-		Code: http.StatusInternalServerError,
-		Text: http.StatusText(http.StatusInternalServerError),
-	}
-	w.WriteHeader(http.StatusInternalServerError)
-	renderTo(w, "page_error", ctx)
+	renderError(w, http.StatusInternalServerError, i18n.M(http.StatusText(http.StatusInternalServerError)))
 }
 
 func Render400(w http.ResponseWriter, text string) {
-	ctx := errcontext{
-		Code: http.StatusBadRequest,
-		Text: text,
-	}
-	w.WriteHeader(http.StatusBadRequest)
-	renderTo(w, "page_error", ctx)
+	renderError(w, http.StatusBadRequest, i18n.M(text))
+}
+
+// Render400Message render bad request page with given message, that is
+// translated together with its arguments.
+func Render400Message(w http.ResponseWriter, m *i18n.Message) {
+	renderError(w, http.StatusBadRequest, m)
 }
 
 func Render404(w http.ResponseWriter, text string) {
-	ctx := errcontext{
-		Code: http.StatusBadRequest,
-		Text: text,
-	}
-	w.WriteHeader(http.StatusNotFound)
-	renderTo(w, "page_error", ctx)
+	renderError(w, http.StatusNotFound, i18n.M(text))
+}
+
+// renderError render error page with the message translated to the locale
+// negotiated for the response.
+func renderError(w http.ResponseWriter, code int, m *i18n.Message) {
+	locale := responseLocale(w)
+	Render(w, code, "page_error", errcontext{
+		Code:   code,
+		Text:   i18n.NewTranslator(locale).Message(m),
+		Locale: locale,
+	})
 }
 
 func Render(w http.ResponseWriter, code int, name string, context interface{}) {
+	if respondJSON(w) {
+		renderJSON(w, code, context)
+		return
+	}
+
 	var b bytes.Buffer
 	if err := renderTo(&b, name, context); err != nil {
+		if perr, ok := err.(*ParseError); ok && tNoCache {
+			renderParseError(w, perr)
+			return
+		}
 		log.Printf("cannot render %q template: %s", name, err)
 		code = http.StatusInternalServerError
 		b.Reset()
-		ctx := errcontext{
-			Code: code,
-			Text: http.StatusText(code),
+		ctx := errcontext{Code: code}
+		if c, ok := context.(Localized); ok {
+			ctx.Locale = c.TemplateLocale()
 		}
+		ctx.Text = i18n.NewTranslator(ctx.Locale).T(http.StatusText(code))
 		if err := renderTo(&b, "page_error", ctx); err != nil {
-			panic(err)
+			log.Printf("cannot render error page: %s", err)
+			http.Error(w, ctx.Text, code)
+			return
 		}
 	}
 	w.WriteHeader(code)
 	b.WriteTo(w)
 }
 
-var tmplFuncs = template.FuncMap{
-	"markdown": markdown,
+// AssetURL return URL of the static file with given name. It is used by the
+// "asset" template function.
+var AssetURL = func(name string) string {
+	return "/static/" + name
 }
 
-func markdown(s string) template.HTML {
-	unsafe := blackfriday.MarkdownCommon([]byte(s))
-	html := bluemonday.UGCPolicy().SanitizeBytes(unsafe)
-	return template.HTML(html)
+var tmplFuncs = template.FuncMap{
+	"markdown": Markdown,
+	"asset": func(name string) string {
+		return AssetURL(name)
+	},
 }
@@ -0,0 +1,82 @@
+package tmpl
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/husio/bb/i18n"
+)
+
+func TestRenderBrokenErrorPage(t *testing.T) {
+	defer func(t map[string]executor, d string) {
+		templates, defaultTheme = t, d
+	}(templates, defaultTheme)
+
+	fsys := fstest.MapFS{
+		"page_error.html": {Data: []byte(`{{define "page_error"}}{{.Missing}}{{end}}`)},
+	}
+	set, err := parseTemplates([]fs.FS{fsys}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	templates = map[string]executor{setKey("", "en"): set}
+	defaultTheme = ""
+
+	w := httptest.NewRecorder()
+	Render404(w, "Topic not found")
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("want 500, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "Internal Server Error") {
+		t.Errorf("unexpected body: %q", w.Body.String())
+	}
+}
+
+func TestRenderErrorTranslated(t *testing.T) {
+	defer func(t map[string]executor, d string) {
+		templates, defaultTheme = t, d
+	}(templates, defaultTheme)
+
+	if err := i18n.Load(os.DirFS("../assets/locales")); err != nil {
+		t.Fatal(err)
+	}
+	fsys := fstest.MapFS{
+		"page_error.html": {Data: []byte(`{{define "page_error"}}{{locale}}: {{.Text}}{{end}}`)},
+	}
+	templates = make(map[string]executor)
+	for _, locale := range i18n.Locales() {
+		set, err := parseTemplates([]fs.FS{fsys}, localeFuncs(i18n.NewTranslator(locale)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		templates[setKey("", locale)] = set
+	}
+	defaultTheme = ""
+
+	cases := map[string]struct {
+		accept string
+		want   string
+	}{
+		"html": {"text/html", "pl: Wiadomość jest za krótka"},
+		"json": {"application/json", `"error":"Wiadomość jest za krótka"`},
+	}
+	for name, tc := range cases {
+		r := httptest.NewRequest("POST", "/t/1/topic/", nil)
+		r.Header.Set("Accept", tc.accept)
+		r.Header.Set("Accept-Language", "pl-PL,pl;q=0.9,en;q=0.8")
+		w := httptest.NewRecorder()
+		Render400Message(Negotiate(w, r), i18n.M("Message too short"))
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: want 400, got %d", name, w.Code)
+		}
+		if !strings.Contains(w.Body.String(), tc.want) {
+			t.Errorf("%s: want %q in body, got %q", name, tc.want, w.Body.String())
+		}
+	}
+}